@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/nategraf/l2bridge-driver/l2bridge"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// options holds the settings of the plugin binary. They are read from the optional config file first, with any
+// command-line flags taking precedence.
+type options struct {
+	Socket     string `yaml:"socket"`
+	Name       string `yaml:"name"`
+	LogLevel   string `yaml:"log_level"`
+	LogFormat  string `yaml:"log_format"`
+	IPTables   bool   `yaml:"iptables"`
+	Firewall   string `yaml:"firewall"`
+	IPForward  bool   `yaml:"ip_forward"`
+	StateDir   string `yaml:"state_dir"`
+	Scope      string `yaml:"scope"`
+	VxlanRange string `yaml:"vni_range"`
+	VlanRange  string `yaml:"vlan_range"`
+	IPAM       bool   `yaml:"ipam"`
+	IPAMSocket string `yaml:"ipam_socket"`
+	configFile string
+}
+
+func defaultOptions() *options {
+	return &options{
+		Name:       "l2bridge",
+		LogLevel:   "info",
+		LogFormat:  "text",
+		IPTables:   true,
+		StateDir:   l2bridge.DefaultStateDir,
+		Scope:      "local",
+		VxlanRange: l2bridge.DefaultVxlanIDRange,
+		VlanRange:  l2bridge.DefaultVlanIDRange,
+		IPAM:       true,
+	}
+}
+
+func (o *options) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&o.configFile, "config", o.configFile, "path to a YAML config file")
+	fs.StringVar(&o.Socket, "socket", o.Socket, "path of the plugin socket (default /run/docker/plugins/<name>.sock)")
+	fs.StringVar(&o.Name, "name", o.Name, "name of the plugin, as used in the driver field of Docker networks")
+	fs.StringVar(&o.LogLevel, "log-level", o.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&o.LogFormat, "log-format", o.LogFormat, "log format: text or json")
+	fs.BoolVar(&o.IPTables, "iptables", o.IPTables, "program iptables rules for l2bridge networks")
+	fs.StringVar(&o.Firewall, "firewall", o.Firewall, "firewall backend: iptables or nftables (default autodetected)")
+	fs.BoolVar(&o.IPForward, "ip-forward", o.IPForward, "enable IP forwarding on the host, and drop forwarded traffic not accepted by a rule")
+	fs.StringVar(&o.StateDir, "state-dir", o.StateDir, "directory in which network state is persisted, empty to disable")
+	fs.StringVar(&o.Scope, "scope", o.Scope, "scope of the networks: local, or global for swarm networks")
+	fs.StringVar(&o.VxlanRange, "vni-range", o.VxlanRange, "range of VXLAN VNIs allocated to global networks")
+	fs.StringVar(&o.VlanRange, "vlan-range", o.VlanRange, "range of VLAN IDs allocated to global networks")
+	fs.BoolVar(&o.IPAM, "ipam", o.IPAM, "serve an IPAM driver which permits overlapping pools, named <name>-ipam")
+	fs.StringVar(&o.IPAMSocket, "ipam-socket", o.IPAMSocket, "path of the IPAM plugin socket (default /run/docker/plugins/<name>-ipam.sock)")
+	return fs
+}
+
+// parseOptions reads the options from the command-line arguments and the config file they name, if any.
+func parseOptions(name string, args []string) (*options, error) {
+	o := defaultOptions()
+	fs := o.flagSet(name)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if o.configFile == "" {
+		return o, nil
+	}
+
+	data, err := ioutil.ReadFile(o.configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, o); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", o.configFile, err)
+	}
+
+	// Parse the arguments again so that flags take precedence over the config file.
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// setupLogging configures the logger according to the options.
+func (o *options) setupLogging() error {
+	level, err := logrus.ParseLevel(o.LogLevel)
+	if err != nil {
+		return err
+	}
+	logrus.SetLevel(level)
+
+	switch o.LogFormat {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format: %s", o.LogFormat)
+	}
+	return nil
+}
+
+// driverConfig returns the driver configuration described by the options.
+func (o *options) driverConfig() *l2bridge.Configuration {
+	return &l2bridge.Configuration{
+		EnableIPForwarding: o.IPForward,
+		EnableIPTables:     o.IPTables,
+		FirewallBackend:    o.Firewall,
+		StateDir:           o.StateDir,
+		Scope:              o.Scope,
+		VxlanIDRange:       o.VxlanRange,
+		VlanIDRange:        o.VlanRange,
+	}
+}
+
+// socketAddress returns the address to serve the plugin on. A plugin name, rather than a path, is resolved to a
+// socket in the Docker plugin directory by the plugin helpers.
+func (o *options) socketAddress() string {
+	if o.Socket != "" {
+		return o.Socket
+	}
+	return o.Name
+}
+
+// ipamSocketAddress returns the address to serve the IPAM plugin on, resolved like the address of the network plugin.
+func (o *options) ipamSocketAddress() string {
+	if o.IPAMSocket != "" {
+		return o.IPAMSocket
+	}
+	return o.Name + "-ipam"
+}
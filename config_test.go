@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nategraf/l2bridge-driver/l2bridge"
+)
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseOptionsDefaults(t *testing.T) {
+	o, err := parseOptions("l2bridge", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *o != *defaultOptions() {
+		t.Errorf("parseOptions() = %+v, expected the defaults %+v", *o, *defaultOptions())
+	}
+	if o.IPForward {
+		t.Error("IP forwarding is enabled by default")
+	}
+	if o.socketAddress() != "l2bridge" || o.ipamSocketAddress() != "l2bridge-ipam" {
+		t.Errorf("Socket addresses = %s and %s, expected the plugin names", o.socketAddress(), o.ipamSocketAddress())
+	}
+}
+
+func TestParseOptionsConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l2bridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeConfigFile(t, dir, "name: custom\nlog_level: debug\nip_forward: true\nvni_range: 100-200\nstate_dir: /tmp/state\n")
+
+	tests := []struct {
+		name  string
+		args  []string
+		check func(o *options) bool
+	}{
+		{
+			name: "config file",
+			args: []string{"-config", path},
+			check: func(o *options) bool {
+				return o.Name == "custom" && o.LogLevel == "debug" && o.IPForward && o.VxlanRange == "100-200" && o.StateDir == "/tmp/state"
+			},
+		},
+		{
+			name: "defaults kept",
+			args: []string{"-config", path},
+			check: func(o *options) bool {
+				return o.LogFormat == "text" && o.IPTables && o.VlanRange == l2bridge.DefaultVlanIDRange
+			},
+		},
+		{
+			name: "flags take precedence",
+			args: []string{"-log-level", "warn", "-config", path, "-ip-forward=false", "-state-dir", ""},
+			check: func(o *options) bool {
+				return o.Name == "custom" && o.LogLevel == "warn" && !o.IPForward && o.StateDir == ""
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		o, err := parseOptions("l2bridge", tt.args)
+		if err != nil {
+			t.Errorf("%s: parseOptions(%v) failed: %v", tt.name, tt.args, err)
+			continue
+		}
+		if !tt.check(o) {
+			t.Errorf("%s: parseOptions(%v) = %+v", tt.name, tt.args, *o)
+		}
+	}
+}
+
+func TestParseOptionsInvalidConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l2bridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name, content string
+	}{
+		{name: "unknown key", content: "name: custom\nip-forward: true\n"},
+		{name: "wrong type", content: "iptables: sometimes\n"},
+		{name: "not a mapping", content: "- name\n"},
+	}
+
+	for _, tt := range tests {
+		path := writeConfigFile(t, dir, tt.content)
+		if o, err := parseOptions("l2bridge", []string{"-config", path}); err == nil {
+			t.Errorf("%s: parseOptions() = %+v, expected an error", tt.name, *o)
+		}
+	}
+
+	if _, err := parseOptions("l2bridge", []string{"-config", filepath.Join(dir, "missing.yml")}); err == nil {
+		t.Error("parseOptions() succeeded with a missing config file")
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	tests := []struct {
+		level, format string
+		wantErr       bool
+	}{
+		{level: "debug", format: "text"},
+		{level: "error", format: "json"},
+		{level: "verbose", format: "text", wantErr: true},
+		{level: "info", format: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		o := &options{LogLevel: tt.level, LogFormat: tt.format}
+		err := o.setupLogging()
+		if tt.wantErr && err == nil {
+			t.Errorf("setupLogging() with level %s and format %s succeeded, expected an error", tt.level, tt.format)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("setupLogging() with level %s and format %s failed: %v", tt.level, tt.format, err)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package l2bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/nategraf/l2bridge-driver/label"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultVxlanIDRange is the range from which VXLAN VNIs are allocated to global scope networks.
+	DefaultVxlanIDRange = "4096-8191"
+	// DefaultVlanIDRange is the range from which VLAN IDs are allocated to global scope networks.
+	DefaultVlanIDRange = "2-4094"
+
+	// autoAllocate is the option value which requests an ID be allocated by the manager.
+	autoAllocate = "auto"
+
+	allocationStorePrefix = "allocation"
+)
+
+// idAllocator hands out IDs from a fixed range, such as VXLAN VNIs or VLAN IDs, to networks.
+type idAllocator struct {
+	start, end int
+	used       map[int]string // key: id, value: network id
+	sync.Mutex
+}
+
+// newIDAllocator returns an allocator for the IDs of a range formatted as "<start>-<end>", bounds included. IDs are
+// positive, and the start of the range may not be past its end.
+func newIDAllocator(idRange string) (*idAllocator, error) {
+	trimmed := strings.TrimSpace(idRange)
+	// The separator is looked for past the first character, so that a negative start is reported as such.
+	sep := -1
+	if len(trimmed) > 0 {
+		if i := strings.Index(trimmed[1:], "-"); i >= 0 {
+			sep = i + 1
+		}
+	}
+	if sep < 0 {
+		return nil, fmt.Errorf("invalid ID range %q: expected <start>-<end>", idRange)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(trimmed[:sep]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID range %q: %v", idRange, err)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(trimmed[sep+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID range %q: %v", idRange, err)
+	}
+	if start < 1 || end < 1 {
+		return nil, fmt.Errorf("invalid ID range %q: IDs must be positive", idRange)
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid ID range %q: start %d is past end %d", idRange, start, end)
+	}
+	return &idAllocator{start: start, end: end, used: make(map[int]string)}, nil
+}
+
+// allocate returns the lowest free ID in the range, and marks it as used by the network.
+func (a *idAllocator) allocate(nid string) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	for id := a.start; id <= a.end; id++ {
+		if _, ok := a.used[id]; !ok {
+			a.used[id] = nid
+			return id, nil
+		}
+	}
+	return 0, types.NoServiceErrorf("no free IDs left in range %d-%d", a.start, a.end)
+}
+
+// reserve marks an ID chosen by the user as used by the network, so that it is not allocated to another. IDs outside
+// of the range are not tracked, but they must still be positive.
+func (a *idAllocator) reserve(id int, nid string) error {
+	if id < 1 {
+		return types.BadRequestErrorf("invalid ID %d: IDs must be positive", id)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if id < a.start || id > a.end {
+		return nil
+	}
+	if owner, ok := a.used[id]; ok && owner != nid {
+		return types.ForbiddenErrorf("ID %d is already allocated to network %s", id, owner)
+	}
+	a.used[id] = nid
+	return nil
+}
+
+// release frees the ID, if it is used by the network.
+func (a *idAllocator) release(id int, nid string) {
+	a.Lock()
+	defer a.Unlock()
+
+	if owner, ok := a.used[id]; ok && owner == nid {
+		delete(a.used, id)
+	}
+}
+
+// networkAllocation records the IDs allocated to a global scope network by the manager.
+type networkAllocation struct {
+	nid     string
+	vxlanID int
+	vlanID  int
+}
+
+func (na *networkAllocation) storePrefix() string {
+	return allocationStorePrefix
+}
+
+func (na *networkAllocation) storeID() string {
+	return na.nid
+}
+
+func (na *networkAllocation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"nid":     na.nid,
+		"VxlanID": na.vxlanID,
+		"VlanID":  na.vlanID,
+	})
+}
+
+func (na *networkAllocation) UnmarshalJSON(b []byte) error {
+	var naMap map[string]interface{}
+	if err := json.Unmarshal(b, &naMap); err != nil {
+		return fmt.Errorf("Failed to unmarshal to network allocation: %v", err)
+	}
+
+	na.nid = naMap["nid"].(string)
+	na.vxlanID = int(naMap["VxlanID"].(float64))
+	na.vlanID = int(naMap["VlanID"].(float64))
+	return nil
+}
+
+func (d *bridgeDriver) initAllocators(config *Configuration) error {
+	vxlanRange, vlanRange := config.VxlanIDRange, config.VlanIDRange
+	if vxlanRange == "" {
+		vxlanRange = DefaultVxlanIDRange
+	}
+	if vlanRange == "" {
+		vlanRange = DefaultVlanIDRange
+	}
+
+	vxlanIDs, err := newIDAllocator(vxlanRange)
+	if err != nil {
+		return err
+	}
+	if vxlanIDs.end > maxVxlanID {
+		return fmt.Errorf("invalid VNI range %q: VNIs are at most %d", vxlanRange, maxVxlanID)
+	}
+	vlanIDs, err := newIDAllocator(vlanRange)
+	if err != nil {
+		return err
+	}
+	if vlanIDs.end > 4094 {
+		return fmt.Errorf("invalid VLAN ID range %q: VLAN IDs are at most 4094", vlanRange)
+	}
+
+	d.Lock()
+	d.vxlanIDs, d.vlanIDs = vxlanIDs, vlanIDs
+	d.allocations = make(map[string]*networkAllocation)
+	d.Unlock()
+	return nil
+}
+
+func (d *bridgeDriver) populateAllocations() error {
+	records, err := d.store.list(allocationStorePrefix)
+	if err != nil {
+		return types.InternalErrorf("failed to get network allocations from store: %v", err)
+	}
+
+	for _, data := range records {
+		na := &networkAllocation{}
+		if err := json.Unmarshal(data, na); err != nil {
+			logrus.WithError(err).Warnf("Failed to decode network allocation record from store")
+			continue
+		}
+		if err := d.reserveAllocation(na); err != nil {
+			logrus.WithError(err).Warnf("Failed to restore allocation for network %.7s: %v", na.nid, err)
+			continue
+		}
+		logrus.Debugf("Allocation for network (%.7s) restored", na.nid)
+	}
+	return nil
+}
+
+func (d *bridgeDriver) reserveAllocation(na *networkAllocation) error {
+	if na.vxlanID != 0 {
+		if err := d.vxlanIDs.reserve(na.vxlanID, na.nid); err != nil {
+			return err
+		}
+	}
+	if na.vlanID != 0 {
+		if err := d.vlanIDs.reserve(na.vlanID, na.nid); err != nil {
+			d.vxlanIDs.release(na.vxlanID, na.nid)
+			return err
+		}
+	}
+
+	d.Lock()
+	d.allocations[na.nid] = na
+	d.Unlock()
+	return nil
+}
+
+// AllocateNetwork is called on a swarm manager to allocate the global resources of a network. The VXLAN VNI and VLAN
+// ID options may be set to "auto" to have an ID allocated from the configured range. The returned options are handed
+// to CreateNetwork on each node.
+func (d *bridgeDriver) AllocateNetwork(nid string, options map[string]string) (map[string]string, error) {
+	d.Lock()
+	_, ok := d.allocations[nid]
+	d.Unlock()
+	if ok {
+		return nil, types.ForbiddenErrorf("network %s is already allocated", nid)
+	}
+
+	na := &networkAllocation{nid: nid}
+	out := make(map[string]string, len(options))
+	for key, value := range options {
+		out[key] = value
+	}
+
+	// Reserve user chosen IDs first, so they are not handed out to this or any other network.
+	if value, ok := options[label.VxlanVNI]; ok && value != autoAllocate {
+		if na.vxlanID, _ = strconv.Atoi(value); na.vxlanID < 1 {
+			return nil, parseErr(label.VxlanVNI, value, "not a VNI or "+autoAllocate)
+		}
+	}
+	if value, ok := options[label.Vlan]; ok && value != autoAllocate {
+		if na.vlanID, _ = strconv.Atoi(value); na.vlanID < 1 {
+			return nil, parseErr(label.Vlan, value, "not a VLAN ID or "+autoAllocate)
+		}
+	}
+	if err := d.reserveAllocation(na); err != nil {
+		return nil, err
+	}
+
+	var err error
+	defer func() {
+		if err != nil {
+			d.freeAllocation(na)
+		}
+	}()
+
+	if options[label.VxlanVNI] == autoAllocate {
+		if na.vxlanID, err = d.vxlanIDs.allocate(nid); err != nil {
+			return nil, err
+		}
+		out[label.VxlanVNI] = strconv.Itoa(na.vxlanID)
+	}
+	if options[label.Vlan] == autoAllocate {
+		if na.vlanID, err = d.vlanIDs.allocate(nid); err != nil {
+			return nil, err
+		}
+		out[label.Vlan] = strconv.Itoa(na.vlanID)
+	}
+
+	if err = d.storeUpdate(na); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FreeNetwork is called on a swarm manager to release the resources allocated to a network.
+func (d *bridgeDriver) FreeNetwork(nid string) error {
+	d.Lock()
+	na, ok := d.allocations[nid]
+	d.Unlock()
+	if !ok {
+		return types.NotFoundErrorf("network %s is not allocated", nid)
+	}
+
+	d.freeAllocation(na)
+	return d.storeDelete(na)
+}
+
+func (d *bridgeDriver) freeAllocation(na *networkAllocation) {
+	d.vxlanIDs.release(na.vxlanID, na.nid)
+	d.vlanIDs.release(na.vlanID, na.nid)
+
+	d.Lock()
+	delete(d.allocations, na.nid)
+	d.Unlock()
+}
@@ -0,0 +1,129 @@
+package l2bridge
+
+import (
+	"testing"
+
+	"github.com/nategraf/l2bridge-driver/label"
+)
+
+func TestNewIDAllocator(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end int
+		wantErr    bool
+	}{
+		{in: "4096-8191", start: 4096, end: 8191},
+		{in: " 2 - 4094 ", start: 2, end: 4094},
+		{in: "10-10", start: 10, end: 10},
+		{in: "10", wantErr: true},
+		{in: "a-10", wantErr: true},
+		{in: "1-b", wantErr: true},
+		{in: "0-10", wantErr: true},
+		{in: "10-9", wantErr: true},
+		{in: "1-2-3", wantErr: true},
+		{in: "-5-10", wantErr: true},
+		{in: "5--10", wantErr: true},
+		{in: "-10--5", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		a, err := newIDAllocator(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("newIDAllocator(%q) = %d-%d, expected an error", tt.in, a.start, a.end)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newIDAllocator(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if a.start != tt.start || a.end != tt.end {
+			t.Errorf("newIDAllocator(%q) = %d-%d, expected %d-%d", tt.in, a.start, a.end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestIDAllocator(t *testing.T) {
+	a, err := newIDAllocator("10-12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// User chosen IDs are skipped by allocations, and those outside of the range are not tracked.
+	if err := a.reserve(11, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reserve(20, "user"); err != nil {
+		t.Fatalf("Reserving an ID out of the range failed: %v", err)
+	}
+	if len(a.used) != 1 {
+		t.Errorf("Expected one ID in use, got %v", a.used)
+	}
+
+	for _, want := range []int{10, 12} {
+		id, err := a.allocate("auto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("allocate() = %d, expected %d", id, want)
+		}
+	}
+	if id, err := a.allocate("auto"); err == nil {
+		t.Errorf("allocate() = %d, expected the range to be exhausted", id)
+	}
+
+	if err := a.reserve(11, "user"); err != nil {
+		t.Errorf("Reserving an ID again for the same network failed: %v", err)
+	}
+	if err := a.reserve(11, "other"); err == nil {
+		t.Error("Reserving an ID of another network succeeded")
+	}
+	for _, id := range []int{0, -1} {
+		if err := a.reserve(id, "user"); err == nil {
+			t.Errorf("Reserving the invalid ID %d succeeded", id)
+		}
+	}
+
+	// Only the network using an ID may release it.
+	a.release(11, "other")
+	if a.used[11] != "user" {
+		t.Errorf("ID 11 was released by another network")
+	}
+	a.release(11, "user")
+	if id, err := a.allocate("auto"); err != nil || id != 11 {
+		t.Errorf("allocate() = %d, %v, expected the released ID 11", id, err)
+	}
+}
+
+func TestAllocateNetworkInvalidIDs(t *testing.T) {
+	d := NewBridgeDriver(&Configuration{})
+	if err := d.initAllocators(&Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, options := range []map[string]string{
+		{label.VxlanVNI: "-5"},
+		{label.VxlanVNI: "0"},
+		{label.Vlan: "-1"},
+		{label.Vlan: "ten"},
+	} {
+		if _, err := d.AllocateNetwork("network0", options); err == nil {
+			t.Errorf("AllocateNetwork(%v) succeeded, expected an error", options)
+		}
+	}
+	if len(d.allocations) != 0 {
+		t.Errorf("Invalid IDs were allocated: %v", d.allocations)
+	}
+
+	out, err := d.AllocateNetwork("network0", map[string]string{label.VxlanVNI: "42", label.Vlan: autoAllocate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[label.VxlanVNI] != "42" || out[label.Vlan] != "2" {
+		t.Errorf("AllocateNetwork() = %v, expected VNI 42 and VLAN 2", out)
+	}
+}
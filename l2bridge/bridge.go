@@ -28,6 +28,7 @@ const (
 	networkType                = "l2bridge"
 	vethPrefix                 = "veth"
 	vethLen                    = 7
+	maxIfaceNameLen            = 15
 	defaultContainerVethPrefix = "eth"
 	maxAllocatePortAttempts    = 10
 )
@@ -46,6 +47,15 @@ type iptablesCleanFuncs []iptableCleanFunc
 type Configuration struct {
 	EnableIPForwarding bool
 	EnableIPTables     bool
+	// StateDir is the directory in which network and endpoint state is persisted. Empty disables persistence.
+	StateDir string
+	// Scope of the networks, either "local" or "global". Global scope networks are allocated by a swarm manager.
+	Scope string
+	// VxlanIDRange and VlanIDRange are the ranges, formatted as "<start>-<end>", from which IDs are allocated.
+	VxlanIDRange string
+	VlanIDRange  string
+	// FirewallBackend is either "iptables" or "nftables". Empty selects iptables if it is installed.
+	FirewallBackend string
 }
 
 // networkConfiguration for network specific configuration
@@ -53,15 +63,38 @@ type networkConfiguration struct {
 	ID                   string
 	BridgeName           string
 	EnableIPv6           bool
+	IPv6AddrGenMode      string
 	Mtu                  int
 	ContainerIfacePrefix string
+	Uplinks              []string
+	Parent               string
+	VlanID               int
+	VlanFiltering        bool
+	StaticFdb            bool
+	Antispoof            bool
+	Isolated             bool
+	Peers                []string
+	HostGateway          bool
+	Masquerade           bool
+	Routes               []*StaticRoute
+	DHCP                 bool
+	DHCPDNS              []net.IP
+	VxlanID              int
+	VxlanRemotes         []net.IP
+	VxlanGroup           net.IP
+	VxlanDev             string
 	// Internal fields set after ipam data parsing
-	PoolIPv4           *net.IPNet
-	PoolIPv6           *net.IPNet
+	NullIPAM    bool
+	SubnetsIPv4 []*subnet
+	SubnetsIPv6 []*subnet
+	// Default gateways given by labels, which are those of the subnets containing them
 	DefaultGatewayIPv4 net.IP
 	DefaultGatewayIPv6 net.IP
-	dbIndex            uint64
-	dbExists           bool
+	// Internal fields set when uplinks are attached to the bridge
+	uplinkStates map[string]*uplinkState
+	vlanCreated  bool
+	dbIndex      uint64
+	dbExists     bool
 }
 
 // ifaceCreator represents how the bridge interface was created
@@ -75,12 +108,19 @@ const (
 
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
 type endpointConfiguration struct {
-	MacAddress net.HardwareAddr
+	MacAddress  net.HardwareAddr
+	VlanID      int
+	Promiscuous bool
+	Routes      []*StaticRoute // Replace the routes of the network when non-nil
+	GatewayIPv4 net.IP
+	GatewayIPv6 net.IP
+	NoGateway   bool
 }
 
 type bridgeEndpoint struct {
 	id           string
 	nid          string
+	hostName     string
 	srcName      string
 	addr         *net.IPNet
 	addrv6       *net.IPNet
@@ -89,27 +129,39 @@ type bridgeEndpoint struct {
 	macAddress   net.HardwareAddr
 	config       *endpointConfiguration // User specified parameters
 	exposedPorts []types.TransportPort
+	cleanFuncs   iptablesCleanFuncs  // Clean functions of the rules programmed for the endpoint
+	external     bool                // Whether external connectivity is programmed for the endpoint
+	portMapping  []types.PortBinding // Published ports of the endpoint
 	dbIndex      uint64
 	dbExists     bool
 }
 
 type bridgeNetwork struct {
-	id            string
-	bridge        *bridgeInterface // The bridge's L3 interface
-	config        *networkConfiguration
-	endpoints     map[string]*bridgeEndpoint // key: endpoint id
-	driver        *bridgeDriver              // The network's driver
-	iptCleanFuncs iptablesCleanFuncs
+	id               string
+	bridge           *bridgeInterface // The bridge's L3 interface
+	config           *networkConfiguration
+	endpoints        map[string]*bridgeEndpoint // key: endpoint id
+	driver           *bridgeDriver              // The network's driver
+	iptCleanFuncs    iptablesCleanFuncs
+	reloadRegistered bool        // whether the firewall reload callback of the network is registered
+	dhcp             *dhcpServer // nil unless the network serves DHCP
 	sync.Mutex
 }
 
 // TODO(nategraf) Consolidate this driver code (ripped from libnetwork/drivers) with the remote driver code.
 type bridgeDriver struct {
-	config        *Configuration
-	network       *bridgeNetwork
-	networks      map[string]*bridgeNetwork
-	nlh           *netlink.Handle
-	configNetwork sync.Mutex
+	config         *Configuration
+	network        *bridgeNetwork
+	networks       map[string]*bridgeNetwork
+	nlh            *netlink.Handle
+	store          *localStore
+	vxlanIDs       *idAllocator
+	vlanIDs        *idAllocator
+	allocations    map[string]*networkAllocation // key: network id
+	firewall       firewall                      // nil if EnableIPTables is false
+	peerForwarding map[peerPair]bool             // Forwarding between peer networks programmed in the firewall
+	ipv6Secret     []byte                        // Secret key of stable privacy IPv6 addresses, loaded on first use
+	configNetwork  sync.Mutex
 	sync.Mutex
 }
 
@@ -117,8 +169,8 @@ type bridgeDriver struct {
 func NewBridgeDriver(config *Configuration) *bridgeDriver {
 	if config == nil {
 		config = &Configuration{
-			EnableIPForwarding: true,
-			EnableIPTables:     true,
+			EnableIPTables: true,
+			StateDir:       DefaultStateDir,
 		}
 	}
 	return &bridgeDriver{networks: map[string]*bridgeNetwork{}, config: config}
@@ -131,22 +183,83 @@ func (c *networkConfiguration) Validate() error {
 		return ErrInvalidMtu(c.Mtu)
 	}
 
-	// If bridge v4 subnet is specified
-	if c.PoolIPv4 != nil {
-		// If default gw is specified, it must be part of bridge subnet
-		if c.DefaultGatewayIPv4 != nil {
-			if !c.PoolIPv4.Contains(c.DefaultGatewayIPv4) {
-				return &ErrInvalidGateway{}
-			}
+	// The gateway of each subnet must be part of its pool
+	for _, sn := range append(append([]*subnet{}, c.SubnetsIPv4...), c.SubnetsIPv6...) {
+		if sn.Gateway != nil && !sn.Pool.Contains(sn.Gateway) {
+			return &ErrInvalidGateway{}
 		}
 	}
 
-	// If default v6 gw is specified, PoolIPv6 must be specified and gw must belong to PoolIPv6 subnet
-	if c.EnableIPv6 && c.DefaultGatewayIPv6 != nil {
-		if c.PoolIPv6 == nil || !c.PoolIPv6.Contains(c.DefaultGatewayIPv6) {
-			return &ErrInvalidGateway{}
+	// If bridge v4 subnets are specified, the default gw, if specified, must be part of one of them
+	if len(c.SubnetsIPv4) > 0 && c.DefaultGatewayIPv4 != nil && c.subnetOf(c.DefaultGatewayIPv4) == nil {
+		return &ErrInvalidGateway{}
+	}
+
+	// If default v6 gw is specified, v6 subnets must be specified and gw must belong to one of them
+	if c.EnableIPv6 && c.DefaultGatewayIPv6 != nil && c.subnetOf(c.DefaultGatewayIPv6) == nil {
+		return &ErrInvalidGateway{}
+	}
+
+	// A VLAN uplink needs both a parent interface and a valid VLAN ID.
+	if c.Parent != "" || c.VlanID != 0 {
+		if c.Parent == "" {
+			return ErrInvalidVlan("a parent interface is required")
+		}
+		if c.VlanID < 1 || c.VlanID > 4094 {
+			return ErrInvalidVlan(fmt.Sprintf("VLAN ID %d is out of range 1-4094", c.VlanID))
+		}
+		if name := c.vlanIfaceName(); len(name) > maxIfaceNameLen {
+			return ErrInvalidVlan(fmt.Sprintf("sub-interface name %s is longer than %d characters", name, maxIfaceNameLen))
+		}
+		if c.VlanFiltering {
+			return ErrInvalidVlan("a sub-interface uplink cannot be used on a VLAN filtering bridge")
 		}
 	}
+
+	// A VXLAN uplink needs a valid VNI, and either static peers or a multicast group on a given device.
+	if c.VxlanID != 0 || len(c.VxlanRemotes) > 0 || c.VxlanGroup != nil || c.VxlanDev != "" {
+		if c.VxlanID < 1 || c.VxlanID > maxVxlanID {
+			return ErrInvalidVxlan(fmt.Sprintf("VNI %d is out of range 1-%d", c.VxlanID, maxVxlanID))
+		}
+		if c.VxlanGroup != nil {
+			if len(c.VxlanRemotes) > 0 {
+				return ErrInvalidVxlan("remote peers and a multicast group are mutually exclusive")
+			}
+			if !c.VxlanGroup.IsMulticast() {
+				return ErrInvalidVxlan(fmt.Sprintf("group %s is not a multicast address", c.VxlanGroup))
+			}
+			if c.VxlanDev == "" {
+				return ErrInvalidVxlan("a multicast group requires a device")
+			}
+		} else if len(c.VxlanRemotes) == 0 {
+			return ErrInvalidVxlan(fmt.Sprintf("%s or %s is required", label.VxlanRemote, label.VxlanGroup))
+		}
+	}
+
+	// The gateway addresses are assigned to the bridge, which is only reachable on the default VLAN when filtering.
+	if c.HostGateway && c.VlanFiltering {
+		return types.BadRequestErrorf("%s cannot be used on a VLAN filtering bridge", label.HostGateway)
+	}
+	if c.Masquerade && !c.HostGateway {
+		return types.BadRequestErrorf("%s requires %s", label.Masquerade, label.HostGateway)
+	}
+
+	if !validAddrGenMode(c.IPv6AddrGenMode) {
+		return types.BadRequestErrorf("invalid %s %q: must be %s, %s or %s", label.IPv6AddrGenMode, c.IPv6AddrGenMode, AddrGenModeEUI64, AddrGenModeStablePrivacy, AddrGenModeRandom)
+	}
+
+	// The DHCP server listens on the bridge, which does not see the traffic of VLANs other than its own.
+	if c.DHCP && c.VlanFiltering {
+		return types.BadRequestErrorf("%s cannot be used on a VLAN filtering bridge", label.DHCP)
+	}
+	if len(c.DHCPDNS) > 0 && !c.DHCP {
+		return types.BadRequestErrorf("%s requires %s", label.DHCPDNS, label.DHCP)
+	}
+
+	// Static forwarding entries are added without a VLAN, which would not match any frame on a VLAN filtering bridge.
+	if c.StaticFdb && c.VlanFiltering {
+		return types.BadRequestErrorf("%s cannot be used on a VLAN filtering bridge", label.StaticFdb)
+	}
 	return nil
 }
 
@@ -208,6 +321,191 @@ func (c *networkConfiguration) fromLabels(labels map[string]interface{}) error {
 			default:
 				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
 			}
+		case label.IPv6AddrGenMode:
+			switch mode := value.(type) {
+			case string:
+				c.IPv6AddrGenMode = mode
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, mode)
+			}
+		case label.Uplink:
+			switch uplinks := value.(type) {
+			case string:
+				c.Uplinks = parseList(uplinks)
+			case []string:
+				c.Uplinks = uplinks
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, uplinks)
+			}
+		case label.HostGateway:
+			switch enable := value.(type) {
+			case bool:
+				c.HostGateway = enable
+			case string:
+				if c.HostGateway, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.Masquerade:
+			switch enable := value.(type) {
+			case bool:
+				c.Masquerade = enable
+			case string:
+				if c.Masquerade, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.Routes:
+			switch routes := value.(type) {
+			case string:
+				if c.Routes, err = parseRoutes(routes); err != nil {
+					return parseErr(key, routes, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, routes)
+			}
+		case label.DHCP:
+			switch enable := value.(type) {
+			case bool:
+				c.DHCP = enable
+			case string:
+				if c.DHCP, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.DHCPDNS:
+			switch servers := value.(type) {
+			case string:
+				for _, server := range parseList(servers) {
+					ip := net.ParseIP(server)
+					if ip == nil || ip.To4() == nil {
+						return fmt.Errorf("failed to parse %s: %v is not a valid IPv4 address", key, server)
+					}
+					c.DHCPDNS = append(c.DHCPDNS, ip)
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, servers)
+			}
+		case label.Peers:
+			switch peers := value.(type) {
+			case string:
+				c.Peers = parseList(peers)
+			case []string:
+				c.Peers = peers
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, peers)
+			}
+		case label.Parent:
+			switch parent := value.(type) {
+			case string:
+				c.Parent = parent
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, parent)
+			}
+		case label.Vlan:
+			switch vlan := value.(type) {
+			case int:
+				c.VlanID = vlan
+			case string:
+				if c.VlanID, err = strconv.Atoi(vlan); err != nil {
+					return parseErr(key, vlan, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, vlan)
+			}
+		case label.VlanFiltering:
+			switch enable := value.(type) {
+			case bool:
+				c.VlanFiltering = enable
+			case string:
+				if c.VlanFiltering, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.StaticFdb:
+			switch enable := value.(type) {
+			case bool:
+				c.StaticFdb = enable
+			case string:
+				if c.StaticFdb, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.Antispoof:
+			switch enable := value.(type) {
+			case bool:
+				c.Antispoof = enable
+			case string:
+				if c.Antispoof, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.Isolated:
+			switch enable := value.(type) {
+			case bool:
+				c.Isolated = enable
+			case string:
+				if c.Isolated, err = strconv.ParseBool(enable); err != nil {
+					return parseErr(key, enable, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, enable)
+			}
+		case label.VxlanVNI:
+			switch vni := value.(type) {
+			case int:
+				c.VxlanID = vni
+			case string:
+				if c.VxlanID, err = strconv.Atoi(vni); err != nil {
+					return parseErr(key, vni, err.Error())
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, vni)
+			}
+		case label.VxlanRemote:
+			switch remotes := value.(type) {
+			case string:
+				for _, remote := range parseList(remotes) {
+					ip := net.ParseIP(remote)
+					if ip == nil {
+						return fmt.Errorf("failed to parse %s: %v is not a valid IP address", key, remote)
+					}
+					c.VxlanRemotes = append(c.VxlanRemotes, ip)
+				}
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, remotes)
+			}
+		case label.VxlanGroup:
+			switch group := value.(type) {
+			case string:
+				c.VxlanGroup = net.ParseIP(group)
+				if c.VxlanGroup == nil {
+					return fmt.Errorf("failed to parse %s: %v is not a valid IP address", key, group)
+				}
+			case net.IP:
+				c.VxlanGroup = group
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, group)
+			}
+		case label.VxlanDev:
+			switch dev := value.(type) {
+			case string:
+				c.VxlanDev = dev
+			default:
+				return fmt.Errorf("unrecognized type for %s: %T", key, dev)
+			}
 		case netlabel.ContainerIfacePrefix:
 			switch prefix := value.(type) {
 			case string:
@@ -227,6 +525,17 @@ func parseErr(key, value, errString string) error {
 	return types.BadRequestErrorf("failed to parse %s value: %v (%s)", key, value, errString)
 }
 
+// parseList splits a comma-separated option value, dropping empty elements.
+func parseList(value string) []string {
+	var out []string
+	for _, elem := range strings.Split(value, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			out = append(out, elem)
+		}
+	}
+	return out
+}
+
 func (n *bridgeNetwork) registerIptCleanFunc(clean iptableCleanFunc) {
 	n.iptCleanFuncs = append(n.iptCleanFuncs, clean)
 }
@@ -239,6 +548,20 @@ func (n *bridgeNetwork) getNetworkBridgeName() string {
 	return config.BridgeName
 }
 
+func (ep *bridgeEndpoint) registerCleanFunc(clean iptableCleanFunc) {
+	ep.cleanFuncs = append(ep.cleanFuncs, clean)
+}
+
+// cleanup removes the rules programmed for the endpoint. Errors are logged, as it is a best effort.
+func (ep *bridgeEndpoint) cleanup() {
+	for _, cleanFunc := range ep.cleanFuncs {
+		if err := cleanFunc(); err != nil {
+			logrus.WithError(err).Warnf("Failed to clean rules for bridge endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	ep.cleanFuncs = nil
+}
+
 func (n *bridgeNetwork) getEndpoint(eid string) (*bridgeEndpoint, error) {
 	n.Lock()
 	defer n.Unlock()
@@ -273,31 +596,38 @@ func (d *bridgeDriver) configure(option map[string]interface{}) error {
 		return &ErrInvalidDriverConfig{}
 	}
 
+	var fw firewall
 	if config.EnableIPTables {
+		var err error
+		if fw, err = newFirewall(config.FirewallBackend); err != nil {
+			return err
+		}
+	}
+
+	// The host is only turned into a router when asked to, as bridging alone needs neither forwarding nor the
+	// netfilter bridge module.
+	if config.EnableIPForwarding {
 		if _, err := os.Stat("/proc/sys/net/bridge"); err != nil {
 			if out, err := exec.Command("modprobe", "-va", "bridge", "br_netfilter").CombinedOutput(); err != nil {
 				logrus.WithError(err).Warnf("Running modprobe bridge br_netfilter failed with message: %s, error: %v", out, err)
 			}
 		}
-	}
-
-	if config.EnableIPForwarding {
-		if err := setupIPForwarding(config.EnableIPTables); err != nil {
-			logrus.WithError(err).Warnf("Failed to setup IP forwarding: ", err)
+		if err := setupIPForwarding(fw); err != nil {
+			logrus.WithError(err).Warnf("Failed to setup IP forwarding: %v", err)
 			return err
 		}
 	}
 
 	d.Lock()
 	d.config = config
+	d.firewall = fw
 	d.Unlock()
 
-	// TODO(nategraf) Implement storage.
-	//if err := d.initStore(option); err != nil {
-	//	return err
-	//}
+	if err := d.initAllocators(config); err != nil {
+		return err
+	}
 
-	return nil
+	return d.initStore()
 }
 
 func (d *bridgeDriver) getNetwork(id string) (*bridgeNetwork, error) {
@@ -350,30 +680,113 @@ func parseNetworkGenericOptions(data interface{}) (*networkConfiguration, error)
 	return config, err
 }
 
+// validateEndpointGateways checks that the gateways given for an endpoint are on the network.
+func (c *networkConfiguration) validateEndpointGateways(ec *endpointConfiguration) error {
+	if ec.NoGateway && (ec.GatewayIPv4 != nil || ec.GatewayIPv6 != nil) {
+		return types.BadRequestErrorf("%s cannot be combined with an endpoint gateway", label.EndpointNoGateway)
+	}
+	if ec.GatewayIPv4 != nil && (ec.GatewayIPv4.To4() == nil || c.subnetOf(ec.GatewayIPv4) == nil) {
+		return &ErrInvalidGateway{}
+	}
+	if ec.GatewayIPv6 != nil && (ec.GatewayIPv6.To4() != nil || c.subnetOf(ec.GatewayIPv6) == nil) {
+		return &ErrInvalidGateway{}
+	}
+	return nil
+}
+
 func (c *networkConfiguration) processIPAM(id string, ipamV4Data, ipamV6Data []*IPAMData) error {
-	if len(ipamV4Data) > 1 || len(ipamV6Data) > 1 {
-		return types.ForbiddenErrorf("l2bridge driver doesn't support multiple subnets")
+	// IPv4 may only be left out of networks which have IPv6 instead.
+	if len(ipamV4Data) == 0 && (!c.EnableIPv6 || len(ipamV6Data) == 0) {
+		return types.BadRequestErrorf("l2bridge network %s requires ipv4 or ipv6 configuration", id)
 	}
 
-	if len(ipamV4Data) == 0 || ipamV4Data[0].Pool == nil {
-		return types.BadRequestErrorf("l2bridge network %s requires ipv4 configuration", id)
+	var err error
+	if isNullPool(ipamV4Data) {
+		if err = c.validateNullIPAM(); err != nil {
+			return err
+		}
+		c.NullIPAM = true
+	} else if c.SubnetsIPv4, err = c.processSubnets(ipamV4Data, DefaultGatewayV4AuxKey, c.DefaultGatewayIPv4); err != nil {
+		return err
+	}
+	if c.SubnetsIPv6, err = c.processSubnets(ipamV6Data, DefaultGatewayV6AuxKey, c.DefaultGatewayIPv6); err != nil {
+		return err
 	}
 
-	c.PoolIPv4 = types.GetIPNetCopy(ipamV4Data[0].Pool)
-	if gw, ok := ipamV4Data[0].AuxAddresses[DefaultGatewayV4AuxKey]; ok {
-		c.DefaultGatewayIPv4 = gw.IP
+	// Options handing out IPv4 addresses make no sense on IPv6-only networks.
+	if len(ipamV4Data) == 0 {
+		if c.DefaultGatewayIPv4 != nil {
+			return &ErrInvalidGateway{}
+		}
+		if c.DHCP {
+			return types.BadRequestErrorf("%s requires an IPv4 subnet", label.DHCP)
+		}
 	}
 
-	if len(ipamV6Data) > 0 {
-		c.PoolIPv6 = ipamV6Data[0].Pool
-		if gw, ok := ipamV6Data[0].AuxAddresses[DefaultGatewayV6AuxKey]; ok {
-			c.DefaultGatewayIPv6 = gw.IP
+	// The host needs an address on every IPv4 subnet to route it.
+	if c.HostGateway {
+		for _, sn := range c.SubnetsIPv4 {
+			if sn.Gateway == nil {
+				return types.BadRequestErrorf("%s requires a gateway address on subnet %s", label.HostGateway, sn.Pool)
+			}
 		}
 	}
 
+	if err = c.Validate(); err != nil {
+		return err
+	}
+	return c.validateRoutes(c.Routes)
+}
+
+// isNullPool tells whether the pool is the one of the null IPAM driver, which covers all addresses and hands none out.
+func isNullPool(ipamData []*IPAMData) bool {
+	if len(ipamData) != 1 || ipamData[0].Pool == nil {
+		return false
+	}
+	ones, _ := ipamData[0].Pool.Mask.Size()
+	return ones == 0
+}
+
+// validateNullIPAM ensures that no option needing IPv4 addresses from IPAM is set on a network using the null IPAM
+// driver. Endpoints of such networks get their addresses from the L2 segment, e.g. from a DHCP server, so the driver
+// knows neither their addresses nor their gateway.
+func (c *networkConfiguration) validateNullIPAM() error {
+	switch {
+	case c.DefaultGatewayIPv4 != nil:
+		return types.BadRequestErrorf("%s cannot be used with the null IPAM driver", label.GatewayIPv4)
+	case c.HostGateway:
+		return types.BadRequestErrorf("%s cannot be used with the null IPAM driver", label.HostGateway)
+	case c.Antispoof:
+		return types.BadRequestErrorf("%s cannot be used with the null IPAM driver", label.Antispoof)
+	case c.DHCP:
+		return types.BadRequestErrorf("%s cannot be used with the null IPAM driver", label.DHCP)
+	}
 	return nil
 }
 
+// processSubnets returns the subnets of the pools. The gateway of a subnet is the one given as auxiliary address, or
+// else the gateway given by label if it falls in the pool. When the host is the gateway, it takes the gateway address
+// reserved by IPAM for subnets with neither.
+func (c *networkConfiguration) processSubnets(ipamData []*IPAMData, auxKey string, labelGateway net.IP) ([]*subnet, error) {
+	var subnets []*subnet
+	for _, data := range ipamData {
+		if data.Pool == nil {
+			return nil, types.BadRequestErrorf("ipam data without a pool")
+		}
+
+		sn := &subnet{Pool: types.GetIPNetCopy(data.Pool)}
+		if gw, ok := data.AuxAddresses[auxKey]; ok {
+			sn.Gateway = gw.IP
+		} else if labelGateway != nil && sn.Pool.Contains(labelGateway) {
+			sn.Gateway = labelGateway
+		} else if c.HostGateway && data.Gateway != nil {
+			sn.Gateway = data.Gateway.IP
+		}
+		subnets = append(subnets, sn)
+	}
+	return subnets, nil
+}
+
 func parseNetworkOptions(id string, option options.Generic) (*networkConfiguration, error) {
 	var (
 		err    error
@@ -401,11 +814,12 @@ func parseNetworkOptions(id string, option options.Generic) (*networkConfigurati
 		config.BridgeName = "br-" + id[:12]
 	}
 
+	// Bridges with VLAN filtering may be shared, which is checked against the existing networks on creation.
 	exists, err := bridgeInterfaceExists(config.BridgeName)
 	if err != nil {
 		return nil, err
 	}
-	if exists {
+	if exists && !config.VlanFiltering {
 		return nil, types.ForbiddenErrorf("interface with name %s exists", config.BridgeName)
 	}
 
@@ -425,11 +839,19 @@ func (d *bridgeDriver) getNetworks() []*bridgeNetwork {
 	return ls
 }
 
+// Return a slice of the networks which use the named bridge
+func (d *bridgeDriver) getBridgeNetworks(bridgeName string) []*bridgeNetwork {
+	var ls []*bridgeNetwork
+	for _, nw := range d.getNetworks() {
+		if nw.getNetworkBridgeName() == bridgeName {
+			ls = append(ls, nw)
+		}
+	}
+	return ls
+}
+
 // Create a new L2 Bridge network, including creating and performing inital setup on the bridge interface.
 func (d *bridgeDriver) CreateNetwork(id string, option map[string]interface{}, ipV4Data, ipV6Data []*IPAMData) error {
-	if len(ipV4Data) == 0 || ipV4Data[0].Pool.String() == "0.0.0.0/0" {
-		return types.BadRequestErrorf("ipv4 pool is empty")
-	}
 	// Sanity checks
 	d.Lock()
 	if _, ok := d.networks[id]; ok {
@@ -438,6 +860,9 @@ func (d *bridgeDriver) CreateNetwork(id string, option map[string]interface{}, i
 	}
 	d.Unlock()
 
+	// The conflict checks look up the uplinks, and the handle is not initialized by configure without a state directory.
+	d.initHandle()
+
 	// Parse and validate the config. It should not be conflict with existing networks' config
 	config, err := parseNetworkOptions(id, option)
 	if err != nil {
@@ -452,22 +877,55 @@ func (d *bridgeDriver) CreateNetwork(id string, option map[string]interface{}, i
 	// so to be consistent we cannot allow that the list changes
 	d.configNetwork.Lock()
 	defer d.configNetwork.Unlock()
+	if err = d.checkUplinkConflicts(config); err != nil {
+		return err
+	}
+	if err = d.checkVlanConflicts(config); err != nil {
+		return err
+	}
+	if err = d.checkSubnetConflicts(config); err != nil {
+		return err
+	}
+	if err = d.checkBridgeSharing(config); err != nil {
+		return err
+	}
+	if err = d.checkVxlanConflicts(config); err != nil {
+		return err
+	}
 	if err = d.createNetwork(config); err != nil {
 		return err
 	}
 
-	return nil //d.storeUpdate(config)
-}
+	// Trunk the VLANs already in use on a shared bridge over the uplinks of the new network.
+	if config.VlanFiltering {
+		if err = d.syncTrunks(config.BridgeName); err != nil {
+			logrus.WithError(err).Warnf("Failed to trunk VLANs on bridge %s: %v", config.BridgeName, err)
+		}
+	}
 
-func (d *bridgeDriver) createNetwork(config *networkConfiguration) (err error) {
-	defer osl.InitOSContext()()
+	// A network which cannot be persisted is torn down, as Docker considers its creation failed.
+	if err = d.storeUpdate(config); err != nil {
+		if err := d.deleteNetwork(config.ID); err != nil {
+			logrus.WithError(err).Warnf("Failed to remove network %s after failing to persist it: %v", config.ID, err)
+		}
+		return err
+	}
+	return nil
+}
 
-	// Initialize handle when needed
+// initHandle initializes the driver's netlink handle when needed.
+func (d *bridgeDriver) initHandle() {
 	d.Lock()
 	if d.nlh == nil {
 		d.nlh = ns.NlHandle()
 	}
 	d.Unlock()
+}
+
+func (d *bridgeDriver) createNetwork(config *networkConfiguration) (err error) {
+	defer osl.InitOSContext()()
+
+	d.initHandle()
 
 	// Create or retrieve the bridge L3 interface
 	bridgeIface, err := newInterface(d.nlh, config)
@@ -488,15 +946,65 @@ func (d *bridgeDriver) createNetwork(config *networkConfiguration) (err error) {
 	d.networks[config.ID] = network
 	d.Unlock()
 
-	// On failure make sure to reset driver network handler to nil
+	// On failure make sure to reset driver network handler to nil, and undo the setup: the rules programmed for the
+	// bridge and the bridge itself are only removed if no other network uses it, and the bridge if it was created here.
+	created := !bridgeIface.exists()
 	defer func() {
 		if err != nil {
+			network.stopDHCPServer()
+			releaseUplinks(d.nlh, config)
+			releaseVlan(d.nlh, config)
+			releaseVxlan(d.nlh, config)
 			d.Lock()
 			delete(d.networks, config.ID)
 			d.Unlock()
+
+			if err := d.syncPeerForwarding(); err != nil {
+				logrus.WithError(err).Warnf("Failed to update peer forwarding rules on network %s rollback: %v", config.ID, err)
+			}
+			if len(d.getBridgeNetworks(config.BridgeName)) > 0 {
+				return
+			}
+			for _, cleanFunc := range network.iptCleanFuncs {
+				if err := cleanFunc(); err != nil {
+					logrus.WithError(err).Warnf("Failed to clean iptables rules for bridge network: %v", err)
+				}
+			}
+			if !created {
+				return
+			}
+			if link, err := d.nlh.LinkByName(config.BridgeName); err == nil {
+				if err := d.nlh.LinkDel(link); err != nil {
+					logrus.WithError(err).Warnf("Failed to remove bridge interface %s on network %s rollback: %v", config.BridgeName, config.ID, err)
+				}
+			}
 		}
 	}()
 
+	if err = network.setupBridge(); err != nil {
+		return err
+	}
+
+	// Serve the addresses of the endpoints, as the segment has no DHCP server of its own.
+	if config.DHCP {
+		err = network.startDHCPServer()
+	}
+	return err
+}
+
+// setupBridge runs the setup steps which bring the bridge device in line with the network configuration. Each step
+// is safe to re-run against a bridge which was already set up.
+func (n *bridgeNetwork) setupBridge() error {
+	n.Lock()
+	config := n.config
+	bridgeIface := n.bridge
+	n.Unlock()
+
+	d := n.driver
+	d.Lock()
+	driverConfig := d.config
+	d.Unlock()
+
 	// Prepare the bridge setup configuration
 	bridgeSetup := newBridgeSetup(config, bridgeIface)
 
@@ -505,16 +1013,57 @@ func (d *bridgeDriver) createNetwork(config *networkConfiguration) (err error) {
 		bridgeSetup.queueStep(setupDevice)
 	}
 
-	// Prevent the bridge from obtaining an IPv6 address.
-	bridgeSetup.queueStep(setupDisableIPv6)
+	// Prevent the bridge from obtaining an IPv6 address, unless it is the IPv6 gateway of the network. The bridge
+	// still needs IPv6 to hold the neighbour entries of the endpoints with static FDB entries.
+	hostGatewayIPv6 := config.HostGateway && config.EnableIPv6 && gatewayOf(config.SubnetsIPv6, nil) != nil
+	if !hostGatewayIPv6 {
+		if config.StaticFdb && config.EnableIPv6 {
+			bridgeSetup.queueStep(setupNeighboursIPv6)
+		} else {
+			bridgeSetup.queueStep(setupDisableIPv6)
+		}
+	}
+
+	// Assign the gateway addresses to the bridge, making the host the gateway of the network.
+	if config.HostGateway {
+		bridgeSetup.queueStep(setupGatewayIPv4)
+		if hostGatewayIPv6 {
+			bridgeSetup.queueStep(setupGatewayIPv6)
+		}
+	}
 
-	if d.config.EnableIPTables {
+	// Attach the host interfaces configured as uplinks.
+	if len(config.Uplinks) > 0 {
+		bridgeSetup.queueStep(setupUplinks)
+	}
+
+	// Enable VLAN filtering so that ports can be assigned to access VLANs.
+	if config.VlanFiltering {
+		bridgeSetup.queueStep(setupVlanFiltering)
+	}
+
+	// Create and attach the VLAN sub-interface uplink.
+	if config.Parent != "" {
+		bridgeSetup.queueStep(setupVlan)
+	}
+
+	// Create and attach the VXLAN uplink.
+	if config.VxlanID != 0 {
+		bridgeSetup.queueStep(setupVxlan)
+	}
+
+	if driverConfig.EnableIPTables {
 		// Setup IPTables.
-		bridgeSetup.queueStep(network.setupIPTables)
+		bridgeSetup.queueStep(n.setupIPTables)
 
 		//We want to track firewalld configuration so that
 		//if it is started/reloaded, the rules can be applied correctly
-		bridgeSetup.queueStep(network.setupFirewalld)
+		bridgeSetup.queueStep(n.setupFirewalld)
+	}
+
+	// Masquerade traffic from the network routed out of the host.
+	if config.Masquerade {
+		bridgeSetup.queueStep(n.setupMasquerade)
 	}
 
 	// Apply the prepared list of steps, and abort at the first error.
@@ -547,24 +1096,37 @@ func (d *bridgeDriver) deleteNetwork(nid string) error {
 	config := n.config
 	n.Unlock()
 
+	n.stopDHCPServer()
+
 	// delete endpoints belong to this network
 	for _, ep := range n.endpoints {
+		// Drop the published ports and external access of endpoints Docker did not revoke, as the rules outlive the bridge.
+		if ep.external {
+			if err := d.setExternalConnectivity(config, ep, false); err != nil {
+				logrus.WithError(err).Warnf("Failed to revoke external connectivity of endpoint %.7s on network %s delete: %v", ep.id, nid, err)
+			}
+			releasePorts(ep.portMapping)
+		}
 		if link, err := d.nlh.LinkByName(ep.srcName); err == nil {
 			if err := d.nlh.LinkDel(link); err != nil {
 				logrus.WithError(err).Errorf("Failed to delete interface (%s)'s link on endpoint (%s) delete", ep.srcName, ep.id)
 			}
 		}
+		ep.cleanup()
 
-		// TODO(nategraf) Implement storage.
-		//if err := d.storeDelete(ep); err != nil {
-		//	logrus.Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
-		//}
+		if err := d.storeDelete(ep); err != nil {
+			logrus.WithError(err).Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
+		}
 	}
 
 	d.Lock()
 	delete(d.networks, nid)
 	d.Unlock()
 
+	if err := d.syncPeerForwarding(); err != nil {
+		logrus.WithError(err).Warnf("Failed to update peer forwarding rules on network %s delete: %v", nid, err)
+	}
+
 	// On failure set network handler back in driver, but
 	// only if is not already taken over by some other thread
 	defer func() {
@@ -577,8 +1139,26 @@ func (d *bridgeDriver) deleteNetwork(nid string) error {
 		}
 	}()
 
-	if err := d.nlh.LinkDel(n.bridge.Link); err != nil {
-		logrus.WithError(err).Warnf("Failed to remove bridge interface %s on network %s delete: %v", config.BridgeName, nid, err)
+	// Release the uplinks before the bridge goes away, so their prior state can be restored. The uplinks of a shared
+	// bridge carry the traffic of every network on it, so they are handed to one of the remaining networks instead.
+	remaining := d.getBridgeNetworks(config.BridgeName)
+	if len(remaining) > 0 {
+		d.handOverUplinks(config, remaining[0])
+	} else {
+		releaseUplinks(d.nlh, config)
+	}
+	releaseVlan(d.nlh, config)
+	releaseVxlan(d.nlh, config)
+
+	// A shared bridge, and the rules programmed for it, are kept until the last network using it is deleted.
+	if len(remaining) > 0 {
+		return d.storeDelete(config)
+	}
+
+	if n.bridge.exists() {
+		if err := d.nlh.LinkDel(n.bridge.Link); err != nil {
+			logrus.WithError(err).Warnf("Failed to remove bridge interface %s on network %s delete: %v", config.BridgeName, nid, err)
+		}
 	}
 
 	for _, cleanFunc := range n.iptCleanFuncs {
@@ -587,8 +1167,7 @@ func (d *bridgeDriver) deleteNetwork(nid string) error {
 		}
 	}
 
-	// TODO(nategraf) Implement storage.
-	return nil // d.storeDelete(config)
+	return d.storeDelete(config)
 }
 
 func addToBridge(nlh *netlink.Handle, ifaceName, bridgeName string) error {
@@ -603,6 +1182,37 @@ func addToBridge(nlh *netlink.Handle, ifaceName, bridgeName string) error {
 	return nil
 }
 
+// attachEndpoint enslaves the host side pipe interface of an endpoint to the network's bridge and configures the port.
+func (d *bridgeDriver) attachEndpoint(config *networkConfiguration, ep *bridgeEndpoint, host netlink.Link) error {
+	hostIfName := host.Attrs().Name
+	if err := addToBridge(d.nlh, hostIfName, config.BridgeName); err != nil {
+		return fmt.Errorf("adding interface %s to bridge %s failed: %v", hostIfName, config.BridgeName, err)
+	}
+
+	// Allow packets to enter and leave the same (bridge) interface, unless the port is isolated, in which case
+	// reflecting frames back out of it would defeat the isolation.
+	isolated := ep.isolated(config)
+	if err := setHairpinMode(d.nlh, host, !isolated); err != nil {
+		return err
+	}
+	if isolated {
+		if err := setPortIsolation(host, true); err != nil {
+			return err
+		}
+	}
+
+	if config.StaticFdb {
+		if err := setupStaticFdb(d.nlh, config, ep, host); err != nil {
+			return err
+		}
+	}
+
+	if config.VlanFiltering {
+		return d.setupAccessVlan(config, ep, host)
+	}
+	return nil
+}
+
 func setHairpinMode(nlh *netlink.Handle, link netlink.Link, enable bool) error {
 	err := nlh.LinkSetHairpin(link, enable)
 	if err != nil && err != syscall.EINVAL {
@@ -663,6 +1273,20 @@ func (d *bridgeDriver) CreateEndpoint(nid, eid string, ei *EndpointInterface, ep
 	if err != nil {
 		return nil, err
 	}
+	if epConfig != nil && epConfig.VlanID != 0 && !n.config.VlanFiltering {
+		return nil, ErrInvalidVlan(fmt.Sprintf("%s requires a network with %s enabled", label.EndpointVlan, label.VlanFiltering))
+	}
+	if epConfig != nil && epConfig.Promiscuous && !n.config.Isolated {
+		return nil, types.BadRequestErrorf("%s requires a network with %s enabled", label.EndpointPromiscuous, label.Isolated)
+	}
+	if epConfig != nil {
+		if err = n.config.validateRoutes(epConfig.Routes); err != nil {
+			return nil, err
+		}
+		if err = n.config.validateEndpointGateways(epConfig); err != nil {
+			return nil, err
+		}
+	}
 
 	// Create and add the endpoint
 	n.Lock()
@@ -741,31 +1365,13 @@ func (d *bridgeDriver) CreateEndpoint(nid, eid string, ei *EndpointInterface, ep
 		}
 	}
 
-	// Attach host side pipe interface into the bridge
-	if err = addToBridge(d.nlh, hostIfName, config.BridgeName); err != nil {
-		return nil, fmt.Errorf("adding interface %s to bridge %s failed: %v", hostIfName, config.BridgeName, err)
-	}
-
-	// Allow packets to enter and leave the same (bridge) interface.
-	err = setHairpinMode(d.nlh, host, true)
-	if err != nil {
-		return nil, err
-	}
-
 	// Store the sandbox side pipe interface parameters
+	endpoint.hostName = hostIfName
 	endpoint.srcName = containerIfName
 	endpoint.macAddress = ei.MacAddress
 	endpoint.addr = ei.Address
 	endpoint.addrv6 = ei.AddressIPv6
 
-	// Set default gateway info if this endpoint is not the networks gatway.
-	if gw := n.config.DefaultGatewayIPv4; gw != nil && !gw.Equal(endpoint.addr.IP) {
-		endpoint.gatewayv4 = gw
-	}
-	if gw := n.config.DefaultGatewayIPv6; gw != nil && !gw.Equal(endpoint.addr.IP) {
-		endpoint.gatewayv6 = gw
-	}
-
 	// Set the sbox's MAC if not provided. If specified, use the one configured by user, otherwise generate one based on IP.
 	eiOut := &EndpointInterface{}
 	if endpoint.macAddress == nil {
@@ -773,38 +1379,60 @@ func (d *bridgeDriver) CreateEndpoint(nid, eid string, ei *EndpointInterface, ep
 		eiOut.MacAddress = endpoint.macAddress
 	}
 
+	// Attach host side pipe interface into the bridge
+	if err = d.attachEndpoint(config, endpoint, host); err != nil {
+		return nil, err
+	}
+
 	// Up the host interface after finishing all netlink configuration
 	if err = d.nlh.LinkSetUp(host); err != nil {
 		return nil, fmt.Errorf("could not set link up for host interface %s: %v", hostIfName, err)
 	}
 
-	if endpoint.addrv6 == nil && config.EnableIPv6 {
-		var ip6 net.IP
-		network := n.config.PoolIPv6
-		if config.PoolIPv6 != nil {
-			network = config.PoolIPv6
+	if endpoint.addrv6 == nil && config.EnableIPv6 && len(config.SubnetsIPv6) > 0 {
+		if endpoint.addrv6, err = n.generateIPv6(config, endpoint); err != nil {
+			return nil, err
 		}
+		eiOut.AddressIPv6 = endpoint.addrv6
+	}
 
-		ones, _ := network.Mask.Size()
-		if ones > 80 {
-			err = types.ForbiddenErrorf("Cannot self generate an IPv6 address on network %v: At least 48 host bits are needed.", network)
-			return nil, err
+	// Set default gateway info if this endpoint is not the networks gatway. The gateway is the one of the subnet the
+	// endpoint is addressed from, unless the endpoint uses a gateway of its own, or none at all.
+	gw4, gw6 := gatewayOf(config.SubnetsIPv4, endpoint.addr), gatewayOf(config.SubnetsIPv6, endpoint.addrv6)
+	if epConfig != nil {
+		if epConfig.GatewayIPv4 != nil {
+			gw4 = epConfig.GatewayIPv4
+		}
+		if epConfig.GatewayIPv6 != nil {
+			gw6 = epConfig.GatewayIPv6
 		}
+		if epConfig.NoGateway {
+			gw4, gw6 = nil, nil
+		}
+	}
+	if gw4 != nil && (endpoint.addr == nil || !gw4.Equal(endpoint.addr.IP)) {
+		endpoint.gatewayv4 = gw4
+	}
+	if gw6 != nil && (endpoint.addrv6 == nil || !gw6.Equal(endpoint.addrv6.IP)) {
+		endpoint.gatewayv6 = gw6
+	}
 
-		ip6 = make(net.IP, len(network.IP))
-		copy(ip6, network.IP)
-		for i, h := range endpoint.macAddress {
-			ip6[i+10] = h
+	if config.Antispoof {
+		defer func() {
+			if err != nil {
+				endpoint.cleanup()
+			}
+		}()
+		if err = endpoint.setupAntispoof(); err != nil {
+			return nil, err
 		}
+	}
 
-		endpoint.addrv6 = &net.IPNet{IP: ip6, Mask: network.Mask}
-		eiOut.AddressIPv6 = endpoint.addrv6
+	if err = d.storeUpdate(endpoint); err != nil {
+		return nil, fmt.Errorf("failed to save bridge endpoint %.7s to store: %v", endpoint.id, err)
 	}
 
-	// TODO(nategraf) Implement storage.
-	//if err = d.storeUpdate(endpoint); err != nil {
-	//	return nil, fmt.Errorf("failed to save bridge endpoint %.7s to store: %v", endpoint.id, err)
-	//}
+	n.addDHCPLease(endpoint)
 
 	return eiOut, nil
 }
@@ -853,10 +1481,20 @@ func (d *bridgeDriver) DeleteEndpoint(nid, eid string) error {
 		}
 	}
 
-	// TODO(nategraf) Implement storage.
-	//if err := d.storeDelete(ep); err != nil {
-	//	logrus.Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
-	//}
+	ep.cleanup()
+	n.removeDHCPLease(ep)
+	if n.config.StaticFdb {
+		releaseStaticFdb(d.nlh, n.config, ep)
+	}
+	if ep.external {
+		if err := d.setExternalConnectivity(n.config, ep, false); err != nil {
+			logrus.WithError(err).Warnf("Failed to revoke external connectivity of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+
+	if err := d.storeDelete(ep); err != nil {
+		logrus.WithError(err).Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
+	}
 
 	return nil
 }
@@ -888,6 +1526,14 @@ func (d *bridgeDriver) EndpointInfo(nid, eid string) (map[string]string, error)
 		m[netlabel.ExposedPorts] = strings.Join(strs, ",")
 	}
 
+	if ep.portMapping != nil {
+		strs := make([]string, 0, len(ep.portMapping))
+		for _, pb := range ep.portMapping {
+			strs = append(strs, pb.String())
+		}
+		m[netlabel.PortMap] = strings.Join(strs, ",")
+	}
+
 	if ep.macAddress != nil {
 		m[netlabel.MacAddress] = ep.macAddress.String()
 	}
@@ -926,23 +1572,138 @@ func (d *bridgeDriver) Join(nid, eid, sboxKey string, opts map[string]interface{
 		ports, err := parseTransportPorts(value)
 		if err == nil {
 			endpoint.exposedPorts = ports
+			if err := d.storeUpdate(endpoint); err != nil {
+				logrus.WithError(err).Warnf("Failed to update bridge endpoint %.7s in store: %v", endpoint.id, err)
+			}
 		} else {
 			logrus.WithError(err).Warnf("parsing of %s failed: %v", netlabel.ExposedPorts, err)
 		}
 	}
 
+	routes := network.config.Routes
+	if endpoint.config != nil && endpoint.config.Routes != nil {
+		routes = endpoint.config.Routes
+	}
+
 	return &JoinResponse{
 		InterfaceName: InterfaceName{
 			SrcName:   endpoint.srcName,
 			DstPrefix: containerVethPrefix,
 		},
-		Gateway:     endpoint.gatewayv4,
-		GatewayIPv6: endpoint.gatewayv6,
+		Gateway:      endpoint.gatewayv4,
+		GatewayIPv6:  endpoint.gatewayv6,
+		StaticRoutes: routes,
 		// Prevent Docker from creating a default gateway for us.
 		DisableGatewayService: true,
 	}, nil
 }
 
+// ProgramExternalConnectivity is invoked after Join for non-internal networks. When the host is the gateway of the
+// network, traffic from the endpoint is allowed to be forwarded out of the host. On pure L2 networks, external
+// connectivity is up to the gateway of the network, and nothing is done.
+func (d *bridgeDriver) ProgramExternalConnectivity(nid, eid string, options map[string]interface{}) error {
+	defer osl.InitOSContext()()
+
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	endpoint, err := network.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if endpoint == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	var bindings []types.PortBinding
+	if value, ok := options[netlabel.PortMap]; ok {
+		if bindings, err = parsePortBindings(value); err != nil {
+			return err
+		}
+	}
+
+	network.Lock()
+	config := network.config
+	network.Unlock()
+	d.Lock()
+	fw := d.firewall
+	d.Unlock()
+
+	if !config.HostGateway {
+		if len(bindings) > 0 {
+			logrus.Warnf("Ignoring published ports of endpoint %.7s: network %.7s has no %s", eid, nid, label.HostGateway)
+		}
+		return nil
+	}
+
+	// The mapping of an endpoint which already has external connectivity is replaced rather than leaked.
+	if endpoint.external {
+		if err = d.setExternalConnectivity(config, endpoint, false); err != nil {
+			return err
+		}
+		releasePorts(endpoint.portMapping)
+		endpoint.external = false
+		endpoint.portMapping = nil
+	}
+
+	// Without a firewall, setExternalConnectivity reports that rules cannot be programmed for any binding.
+	if endpoint.portMapping, err = endpoint.mapPorts(bindings, fw == nil || fw.supportsIPv6()); err != nil {
+		return err
+	}
+	if err = d.setExternalConnectivity(config, endpoint, true); err != nil {
+		if err := d.setExternalConnectivity(config, endpoint, false); err != nil {
+			logrus.WithError(err).Warnf("Failed to clean up external connectivity of endpoint %.7s: %v", eid, err)
+		}
+		releasePorts(endpoint.portMapping)
+		endpoint.portMapping = nil
+		return err
+	}
+	endpoint.external = true
+
+	if err = d.storeUpdate(endpoint); err != nil {
+		return fmt.Errorf("failed to update bridge endpoint %.7s to store: %v", endpoint.id, err)
+	}
+	return nil
+}
+
+// RevokeExternalConnectivity is invoked before Leave to remove the external connectivity of the endpoint.
+func (d *bridgeDriver) RevokeExternalConnectivity(nid, eid string) error {
+	defer osl.InitOSContext()()
+
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	endpoint, err := network.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if endpoint == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	if !endpoint.external {
+		return nil
+	}
+
+	network.Lock()
+	config := network.config
+	network.Unlock()
+
+	if err = d.setExternalConnectivity(config, endpoint, false); err != nil {
+		return err
+	}
+	releasePorts(endpoint.portMapping)
+	endpoint.external = false
+	endpoint.portMapping = nil
+
+	if err = d.storeUpdate(endpoint); err != nil {
+		return fmt.Errorf("failed to update bridge endpoint %.7s to store: %v", endpoint.id, err)
+	}
+	return nil
+}
+
 // Leave method is invoked when a Sandbox detaches from an endpoint.
 // Currently this is just a couple sanity checks to better report errors.
 func (d *bridgeDriver) Leave(nid, eid string) error {
@@ -979,9 +1740,141 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[label.EndpointVlan]; ok {
+		var err error
+		switch vlan := opt.(type) {
+		case string:
+			if ec.VlanID, err = strconv.Atoi(vlan); err != nil {
+				return nil, parseErr(label.EndpointVlan, vlan, err.Error())
+			}
+		case float64:
+			ec.VlanID = int(vlan)
+		default:
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		if ec.VlanID < 1 || ec.VlanID > 4094 {
+			return nil, ErrInvalidVlan(fmt.Sprintf("VLAN ID %d is out of range 1-4094", ec.VlanID))
+		}
+	}
+
+	if opt, ok := epOptions[label.EndpointRoutes]; ok {
+		routes, ok := opt.(string)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		var err error
+		if ec.Routes, err = parseRoutes(routes); err != nil {
+			return nil, parseErr(label.EndpointRoutes, routes, err.Error())
+		}
+	}
+
+	for key, gw := range map[string]*net.IP{label.EndpointGatewayIPv4: &ec.GatewayIPv4, label.EndpointGatewayIPv6: &ec.GatewayIPv6} {
+		if opt, ok := epOptions[key]; ok {
+			ip, ok := opt.(string)
+			if !ok {
+				return nil, &ErrInvalidEndpointConfig{}
+			}
+			if *gw = net.ParseIP(ip); *gw == nil {
+				return nil, parseErr(key, ip, "not a valid IP address")
+			}
+		}
+	}
+
+	if opt, ok := epOptions[label.EndpointNoGateway]; ok {
+		var err error
+		switch disable := opt.(type) {
+		case string:
+			if ec.NoGateway, err = strconv.ParseBool(disable); err != nil {
+				return nil, parseErr(label.EndpointNoGateway, disable, err.Error())
+			}
+		case bool:
+			ec.NoGateway = disable
+		default:
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[label.EndpointPromiscuous]; ok {
+		var err error
+		switch enable := opt.(type) {
+		case string:
+			if ec.Promiscuous, err = strconv.ParseBool(enable); err != nil {
+				return nil, parseErr(label.EndpointPromiscuous, enable, err.Error())
+			}
+		case bool:
+			ec.Promiscuous = enable
+		default:
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
 	return ec, nil
 }
 
+// parsePortBindings unpacks the opaque port bindings array passed by libnetwork.
+func parsePortBindings(in interface{}) ([]types.PortBinding, error) {
+	slice, ok := in.([]interface{})
+	if !ok {
+		return nil, &ErrInvalidPortBindingsOption{}
+	}
+
+	var out []types.PortBinding
+	for _, value := range slice {
+		dict, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, &ErrInvalidPortBindingsOption{}
+		}
+
+		var pb types.PortBinding
+		if proto, ok := dict["Proto"]; ok {
+			switch x := proto.(type) {
+			case float64:
+				pb.Proto = types.Protocol(x)
+			default:
+				return nil, &ErrInvalidPortBindingsOption{}
+			}
+		} else {
+			return nil, &ErrInvalidPortBindingsOption{}
+		}
+
+		if port, ok := dict["Port"]; ok {
+			switch x := port.(type) {
+			case float64:
+				pb.Port = uint16(x)
+			default:
+				return nil, &ErrInvalidPortBindingsOption{}
+			}
+		} else {
+			return nil, &ErrInvalidPortBindingsOption{}
+		}
+
+		if hostPort, ok := dict["HostPort"]; ok {
+			switch x := hostPort.(type) {
+			case float64:
+				pb.HostPort = uint16(x)
+			default:
+				return nil, &ErrInvalidPortBindingsOption{}
+			}
+		}
+
+		if hostIP, ok := dict["HostIP"]; ok {
+			switch x := hostIP.(type) {
+			case nil:
+			case string:
+				if x != "" {
+					if pb.HostIP = net.ParseIP(x); pb.HostIP == nil {
+						return nil, &ErrInvalidPortBindingsOption{}
+					}
+				}
+			default:
+				return nil, &ErrInvalidPortBindingsOption{}
+			}
+		}
+		out = append(out, pb)
+	}
+	return out, nil
+}
+
 // parseTransportPorts unpacks the opaque transport ports array passed by libnetwork.
 func parseTransportPorts(in interface{}) ([]types.TransportPort, error) {
 	slice, ok := in.([]interface{})
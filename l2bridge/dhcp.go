@@ -0,0 +1,464 @@
+package l2bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	dhcpLeaseTime  = time.Hour
+
+	// Renewals are unicast to the server identifier, which the server does not own, so they are never answered and
+	// clients only keep their lease by rebinding, which is broadcast. The rebinding time is therefore brought close to
+	// the renewal time, instead of the 7/8 of the lease time clients default to.
+	dhcpRenewalTime   = dhcpLeaseTime / 2
+	dhcpRebindingTime = dhcpRenewalTime + time.Minute
+
+	// dhcpRecvTimeout bounds how long the server blocks on its socket, and so how long it takes to notice it was stopped.
+	dhcpRecvTimeout = time.Second
+
+	// Fixed size part of a BOOTP message, up to and including the DHCP magic cookie, and the minimum size of a message.
+	bootpHeaderLen = 240
+	bootpMinLen    = 300
+	bootpRequest   = 1
+	bootpReply     = 2
+	bootpBroadcast = 0x8000
+)
+
+var dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+// DHCP message types.
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+	dhcpInform   = 8
+)
+
+// DHCP options.
+const (
+	dhcpOptPad            = 0
+	dhcpOptSubnetMask     = 1
+	dhcpOptRouter         = 3
+	dhcpOptDNS            = 6
+	dhcpOptRequestedIP    = 50
+	dhcpOptLeaseTime      = 51
+	dhcpOptMessageType    = 53
+	dhcpOptServerID       = 54
+	dhcpOptRenewalTime    = 58
+	dhcpOptRebindingTime  = 59
+	dhcpOptClasslessRoute = 121
+	dhcpOptEnd            = 255
+)
+
+// dhcpLease is the configuration handed to the endpoint owning a MAC address.
+type dhcpLease struct {
+	addr     *net.IPNet
+	gateway  net.IP
+	serverID net.IP
+	dns      []net.IP
+	routes   []*StaticRoute
+}
+
+// dhcpServer answers DHCPv4 requests on a bridge with the leases of the network's endpoints. As the bridge has no IP
+// address, it reads and writes IP packets on a raw socket bound to the bridge. It only answers clients it has a lease
+// for, so that it can coexist with other DHCP servers on the segment.
+type dhcpServer struct {
+	bridgeName string
+	ifindex    int
+	fd         int
+	leases     map[string]*dhcpLease // key: mac address
+	done       chan struct{}
+	sync.Mutex
+}
+
+// htons converts a short to network byte order, as expected by packet sockets: its big endian encoding, read back in
+// the byte order of the host.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+func newDHCPServer(bridgeName string) (*dhcpServer, error) {
+	iface, err := net.InterfaceByName(bridgeName)
+	if err != nil {
+		return nil, fmt.Errorf("could not find bridge %s: %v", bridgeName, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_IP)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DHCP socket on bridge %s: %v", bridgeName, err)
+	}
+	tv := syscall.NsecToTimeval(dhcpRecvTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to set DHCP socket timeout on bridge %s: %v", bridgeName, err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_IP), Ifindex: iface.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to bind DHCP socket to bridge %s: %v", bridgeName, err)
+	}
+
+	s := &dhcpServer{
+		bridgeName: bridgeName,
+		ifindex:    iface.Index,
+		fd:         fd,
+		leases:     make(map[string]*dhcpLease),
+		done:       make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// stop shuts the server down. The socket is closed by the serving goroutine once it notices.
+func (s *dhcpServer) stop() {
+	close(s.done)
+}
+
+func (s *dhcpServer) addLease(mac net.HardwareAddr, lease *dhcpLease) {
+	s.Lock()
+	s.leases[mac.String()] = lease
+	s.Unlock()
+}
+
+func (s *dhcpServer) removeLease(mac net.HardwareAddr) {
+	s.Lock()
+	delete(s.leases, mac.String())
+	s.Unlock()
+}
+
+func (s *dhcpServer) serve() {
+	defer syscall.Close(s.fd)
+
+	buf := make([]byte, 65536)
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			logrus.WithError(err).Errorf("DHCP server on bridge %s failed: %v", s.bridgeName, err)
+			return
+		}
+
+		msg := udpPayload(buf[:n], dhcpServerPort)
+		if msg == nil {
+			continue
+		}
+		if err := s.handle(msg); err != nil {
+			logrus.WithError(err).Warnf("Failed to answer DHCP request on bridge %s: %v", s.bridgeName, err)
+		}
+	}
+}
+
+// udpPayload returns the payload of an IPv4 UDP packet sent to the port, or nil if the packet is anything else.
+func udpPayload(pkt []byte, port uint16) []byte {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 || pkt[9] != syscall.IPPROTO_UDP {
+		return nil
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if len(pkt) < ihl+8 || binary.BigEndian.Uint16(pkt[ihl+2:]) != port {
+		return nil
+	}
+	udpLen := int(binary.BigEndian.Uint16(pkt[ihl+4:]))
+	if udpLen < 8 || len(pkt) < ihl+udpLen {
+		return nil
+	}
+	return pkt[ihl+8 : ihl+udpLen]
+}
+
+// parseDHCPOptions returns the options of a DHCP message, or nil if the message is malformed.
+func parseDHCPOptions(msg []byte) map[byte][]byte {
+	if len(msg) < bootpHeaderLen || string(msg[236:240]) != string(dhcpMagicCookie) {
+		return nil
+	}
+
+	opts := make(map[byte][]byte)
+	for b := msg[bootpHeaderLen:]; len(b) > 0; {
+		code := b[0]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil
+		}
+		opts[code] = b[2 : 2+b[1]]
+		b = b[2+b[1]:]
+	}
+	return opts
+}
+
+func (s *dhcpServer) handle(msg []byte) error {
+	opts := parseDHCPOptions(msg)
+	if opts == nil || msg[0] != bootpRequest || msg[1] != 1 || msg[2] != 6 || len(opts[dhcpOptMessageType]) != 1 {
+		return nil
+	}
+	mac := net.HardwareAddr(msg[28:34])
+
+	s.Lock()
+	lease, ok := s.leases[mac.String()]
+	s.Unlock()
+	if !ok {
+		logrus.Debugf("Ignoring DHCP request from unknown client %s on bridge %s", mac, s.bridgeName)
+		return nil
+	}
+
+	switch msgType := opts[dhcpOptMessageType][0]; msgType {
+	case dhcpDiscover:
+		return s.reply(msg, lease, dhcpOffer, false)
+	case dhcpRequest:
+		// A client which picked another server's offer is none of our concern.
+		if id, ok := opts[dhcpOptServerID]; ok && !net.IP(id).Equal(lease.serverID) {
+			return nil
+		}
+		requested := net.IP(msg[12:16])
+		if ip, ok := opts[dhcpOptRequestedIP]; ok && len(ip) == net.IPv4len {
+			requested = net.IP(ip)
+		}
+		if !requested.Equal(lease.addr.IP) {
+			return s.reply(msg, lease, dhcpNak, false)
+		}
+		return s.reply(msg, lease, dhcpAck, false)
+	case dhcpInform:
+		return s.reply(msg, lease, dhcpAck, true)
+	case dhcpDecline, dhcpRelease:
+		logrus.Debugf("DHCP client %s on bridge %s sent message type %d", mac, s.bridgeName, msgType)
+	}
+	return nil
+}
+
+// reply answers the request with a message of the given type, carrying the lease unless it is a NAK. Replies to an
+// inform carry the configuration only, as the client already has an address.
+func (s *dhcpServer) reply(req []byte, lease *dhcpLease, msgType byte, inform bool) error {
+	msg := make([]byte, bootpHeaderLen, bootpMinLen)
+	msg[0], msg[1], msg[2] = bootpReply, 1, 6
+	copy(msg[4:8], req[4:8])     // xid
+	copy(msg[10:12], req[10:12]) // flags
+	copy(msg[24:44], req[24:44]) // giaddr, chaddr
+	copy(msg[236:240], dhcpMagicCookie)
+
+	msg = appendDHCPOption(msg, dhcpOptMessageType, []byte{msgType})
+	msg = appendDHCPOption(msg, dhcpOptServerID, lease.serverID.To4())
+	if msgType != dhcpNak {
+		if inform {
+			copy(msg[12:16], req[12:16])
+		} else {
+			copy(msg[16:20], lease.addr.IP.To4())
+			msg = appendDHCPOption(msg, dhcpOptLeaseTime, dhcpSeconds(dhcpLeaseTime))
+			msg = appendDHCPOption(msg, dhcpOptRenewalTime, dhcpSeconds(dhcpRenewalTime))
+			msg = appendDHCPOption(msg, dhcpOptRebindingTime, dhcpSeconds(dhcpRebindingTime))
+		}
+		msg = appendDHCPOption(msg, dhcpOptSubnetMask, net.IP(lease.addr.Mask).To4())
+		if lease.gateway != nil {
+			msg = appendDHCPOption(msg, dhcpOptRouter, lease.gateway.To4())
+		}
+		if len(lease.dns) > 0 {
+			var dns []byte
+			for _, ip := range lease.dns {
+				dns = append(dns, ip.To4()...)
+			}
+			msg = appendDHCPOption(msg, dhcpOptDNS, dns)
+		}
+		if routes := lease.classlessRoutes(); len(routes) > 0 {
+			msg = appendDHCPOption(msg, dhcpOptClasslessRoute, routes)
+		}
+	}
+	msg = append(msg, dhcpOptEnd)
+	for len(msg) < bootpMinLen {
+		msg = append(msg, dhcpOptPad)
+	}
+
+	// Replies go to the client's address if it has one, and are broadcast if it asks so or is refused, as per RFC 2131.
+	dstMAC, dstIP := net.HardwareAddr(req[28:34]), net.IP(msg[16:20])
+	switch {
+	case msgType == dhcpNak || binary.BigEndian.Uint16(req[10:12])&bootpBroadcast != 0:
+		dstMAC, dstIP = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, net.IPv4bcast.To4()
+	case !net.IP(req[12:16]).Equal(net.IPv4zero):
+		dstIP = net.IP(req[12:16])
+	}
+
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_IP), Ifindex: s.ifindex, Halen: 6}
+	copy(addr.Addr[:], dstMAC)
+	return syscall.Sendto(s.fd, udpPacket(lease.serverID.To4(), dstIP, msg), 0, addr)
+}
+
+func appendDHCPOption(msg []byte, code byte, value []byte) []byte {
+	return append(append(msg, code, byte(len(value))), value...)
+}
+
+// dhcpSeconds encodes a duration as the value of a DHCP time option.
+func dhcpSeconds(d time.Duration) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(d/time.Second))
+	return b
+}
+
+// classlessRoutes encodes the routes of the lease as RFC 3442 classless static routes. Clients ignore the router
+// option when given these, so the default route is included.
+func (l *dhcpLease) classlessRoutes() []byte {
+	if len(l.routes) == 0 {
+		return nil
+	}
+
+	var b []byte
+	if l.gateway != nil {
+		b = append(b, 0)
+		b = append(b, l.gateway.To4()...)
+	}
+	for _, route := range l.routes {
+		dst := route.Destination.IP.To4()
+		if dst == nil {
+			continue
+		}
+		ones, _ := route.Destination.Mask.Size()
+		b = append(b, byte(ones))
+		b = append(b, dst[:(ones+7)/8]...)
+		if route.RouteType == types.NEXTHOP {
+			b = append(b, route.NextHop.To4()...)
+		} else {
+			b = append(b, net.IPv4zero.To4()...)
+		}
+	}
+	return b
+}
+
+// udpPacket wraps the payload in UDP and IPv4 headers, from the DHCP server port to the client port. The UDP checksum
+// is optional over IPv4, and left out.
+func udpPacket(src, dst net.IP, payload []byte) []byte {
+	pkt := make([]byte, 28, 28+len(payload))
+	pkt[0] = 0x45 // version 4, 20 byte header
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)+len(payload)))
+	pkt[8] = 64 // ttl
+	pkt[9] = syscall.IPPROTO_UDP
+	copy(pkt[12:16], src)
+	copy(pkt[16:20], dst.To4())
+	binary.BigEndian.PutUint16(pkt[10:], ipChecksum(pkt[:20]))
+
+	binary.BigEndian.PutUint16(pkt[20:], dhcpServerPort)
+	binary.BigEndian.PutUint16(pkt[22:], dhcpClientPort)
+	binary.BigEndian.PutUint16(pkt[24:], uint16(8+len(payload)))
+	return append(pkt, payload...)
+}
+
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// dhcpLease returns the lease of the endpoint, or nil if it has no IPv4 address. The server identifies itself with the
+// gateway of the endpoint's subnet, or the first address of the subnet if it has none, as the bridge has no address
+// of its own.
+func (c *networkConfiguration) dhcpLease(ep *bridgeEndpoint) *dhcpLease {
+	if ep.addr == nil || ep.addr.IP.To4() == nil {
+		return nil
+	}
+
+	serverID := nextIP(ep.addr.IP.Mask(ep.addr.Mask))
+	if sn := c.subnetOf(ep.addr.IP); sn != nil && sn.Gateway != nil {
+		serverID = sn.Gateway
+	}
+	routes := c.Routes
+	if ep.config != nil && ep.config.Routes != nil {
+		routes = ep.config.Routes
+	}
+	return &dhcpLease{
+		addr:     ep.addr,
+		gateway:  ep.gatewayv4,
+		serverID: serverID,
+		dns:      c.DHCPDNS,
+		routes:   routes,
+	}
+}
+
+// startDHCPServer starts the DHCP server of the network on its bridge, with a lease for each of its endpoints. A server
+// which is already running is replaced.
+func (n *bridgeNetwork) startDHCPServer() error {
+	n.stopDHCPServer()
+
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	n.Unlock()
+
+	s, err := newDHCPServer(bridgeName)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	n.dhcp = s
+	endpoints := make([]*bridgeEndpoint, 0, len(n.endpoints))
+	for _, ep := range n.endpoints {
+		endpoints = append(endpoints, ep)
+	}
+	n.Unlock()
+
+	for _, ep := range endpoints {
+		n.addDHCPLease(ep)
+	}
+	return nil
+}
+
+func (n *bridgeNetwork) stopDHCPServer() {
+	n.Lock()
+	s := n.dhcp
+	n.dhcp = nil
+	n.Unlock()
+
+	if s != nil {
+		s.stop()
+	}
+}
+
+func (n *bridgeNetwork) addDHCPLease(ep *bridgeEndpoint) {
+	n.Lock()
+	s, config := n.dhcp, n.config
+	n.Unlock()
+
+	if s == nil {
+		return
+	}
+	if lease := config.dhcpLease(ep); lease != nil {
+		s.addLease(ep.macAddress, lease)
+	}
+}
+
+func (n *bridgeNetwork) removeDHCPLease(ep *bridgeEndpoint) {
+	n.Lock()
+	s := n.dhcp
+	n.Unlock()
+
+	if s != nil {
+		s.removeLease(ep.macAddress)
+	}
+}
@@ -0,0 +1,242 @@
+package l2bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// dhcpMessage builds a BOOTP request carrying the given options, in the encoding of parseDHCPOptions.
+func dhcpMessage(opts ...[]byte) []byte {
+	msg := make([]byte, bootpHeaderLen)
+	msg[0], msg[1], msg[2] = bootpRequest, 1, 6
+	copy(msg[236:240], dhcpMagicCookie)
+	for _, opt := range opts {
+		msg = append(msg, opt...)
+	}
+	return msg
+}
+
+func TestParseDHCPOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want map[byte][]byte // nil if the message is malformed
+	}{
+		{
+			name: "no options",
+			msg:  dhcpMessage([]byte{dhcpOptEnd}),
+			want: map[byte][]byte{},
+		},
+		{
+			name: "options",
+			msg:  dhcpMessage([]byte{dhcpOptMessageType, 1, dhcpRequest}, []byte{dhcpOptRequestedIP, 4, 192, 168, 0, 2}, []byte{dhcpOptEnd}),
+			want: map[byte][]byte{dhcpOptMessageType: {dhcpRequest}, dhcpOptRequestedIP: {192, 168, 0, 2}},
+		},
+		{
+			name: "padding",
+			msg:  dhcpMessage([]byte{dhcpOptPad, dhcpOptPad, dhcpOptMessageType, 1, dhcpDiscover, dhcpOptPad, dhcpOptEnd}),
+			want: map[byte][]byte{dhcpOptMessageType: {dhcpDiscover}},
+		},
+		{
+			name: "no end",
+			msg:  dhcpMessage([]byte{dhcpOptMessageType, 1, dhcpInform}),
+			want: map[byte][]byte{dhcpOptMessageType: {dhcpInform}},
+		},
+		{
+			name: "after end",
+			msg:  dhcpMessage([]byte{dhcpOptEnd, dhcpOptMessageType, 1, dhcpInform}),
+			want: map[byte][]byte{},
+		},
+		{
+			name: "truncated option",
+			msg:  dhcpMessage([]byte{dhcpOptRequestedIP, 4, 192, 168}),
+		},
+		{
+			name: "truncated length",
+			msg:  dhcpMessage([]byte{dhcpOptRequestedIP}),
+		},
+		{
+			name: "short message",
+			msg:  dhcpMessage()[:bootpHeaderLen-1],
+		},
+		{
+			name: "bad cookie",
+			msg:  append(make([]byte, bootpHeaderLen), dhcpOptEnd),
+		},
+	}
+
+	for _, tt := range tests {
+		got := parseDHCPOptions(tt.msg)
+		if tt.want == nil {
+			if got != nil {
+				t.Errorf("%s: parseDHCPOptions() = %v, expected nil", tt.name, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("%s: parseDHCPOptions() = nil, expected %v", tt.name, tt.want)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: parseDHCPOptions() = %v, expected %v", tt.name, got, tt.want)
+			continue
+		}
+		for code, value := range tt.want {
+			if !bytes.Equal(got[code], value) {
+				t.Errorf("%s: option %d = %v, expected %v", tt.name, code, got[code], value)
+			}
+		}
+	}
+}
+
+func TestUDPPacket(t *testing.T) {
+	src, dst := net.ParseIP("192.168.0.1"), net.ParseIP("192.168.0.2")
+	payload := []byte("payload")
+	pkt := udpPacket(src.To4(), dst, payload)
+
+	if len(pkt) != 28+len(payload) {
+		t.Fatalf("Expected a packet of %d bytes, got %d", 28+len(payload), len(pkt))
+	}
+	if pkt[0] != 0x45 || pkt[9] != 17 {
+		t.Errorf("Expected an IPv4 UDP header, got version/length %#x and protocol %d", pkt[0], pkt[9])
+	}
+	if got := binary.BigEndian.Uint16(pkt[2:]); int(got) != len(pkt) {
+		t.Errorf("IP total length = %d, expected %d", got, len(pkt))
+	}
+	if !net.IP(pkt[12:16]).Equal(src) || !net.IP(pkt[16:20]).Equal(dst) {
+		t.Errorf("Addresses = %s -> %s, expected %s -> %s", net.IP(pkt[12:16]), net.IP(pkt[16:20]), src, dst)
+	}
+	if ipChecksum(pkt[:20]) != 0 {
+		t.Errorf("IP header checksum %#x does not verify", binary.BigEndian.Uint16(pkt[10:]))
+	}
+	if sport, dport := binary.BigEndian.Uint16(pkt[20:]), binary.BigEndian.Uint16(pkt[22:]); sport != dhcpServerPort || dport != dhcpClientPort {
+		t.Errorf("Ports = %d -> %d, expected %d -> %d", sport, dport, dhcpServerPort, dhcpClientPort)
+	}
+	if got := binary.BigEndian.Uint16(pkt[24:]); int(got) != 8+len(payload) {
+		t.Errorf("UDP length = %d, expected %d", got, 8+len(payload))
+	}
+
+	// The server reads packets back with udpPayload, which must see through the headers.
+	pkt[20], pkt[21], pkt[22], pkt[23] = pkt[22], pkt[23], pkt[20], pkt[21]
+	if got := udpPayload(pkt, dhcpServerPort); !bytes.Equal(got, payload) {
+		t.Errorf("udpPayload() = %q, expected %q", got, payload)
+	}
+	if got := udpPayload(pkt, dhcpClientPort); got != nil {
+		t.Errorf("udpPayload() on another port = %q, expected nil", got)
+	}
+	if got := udpPayload(pkt[:25], dhcpServerPort); got != nil {
+		t.Errorf("udpPayload() of a truncated packet = %q, expected nil", got)
+	}
+}
+
+func TestHtons(t *testing.T) {
+	var b [2]byte
+	*(*uint16)(unsafe.Pointer(&b[0])) = htons(0x0800)
+	if b != [2]byte{0x08, 0x00} {
+		t.Errorf("htons(0x0800) is laid out as %#v in memory, expected network byte order", b)
+	}
+}
+
+func TestClasslessRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		gateway string
+		routes  string
+		want    []byte
+	}{
+		{name: "no routes", gateway: "192.168.0.1"},
+		{
+			name:    "default route first",
+			gateway: "192.168.0.1",
+			routes:  "10.0.0.0/8 via 192.168.0.254",
+			want:    []byte{0, 192, 168, 0, 1, 8, 10, 192, 168, 0, 254},
+		},
+		{
+			name:   "no gateway",
+			routes: "10.0.0.0/8 via 192.168.0.254",
+			want:   []byte{8, 10, 192, 168, 0, 254},
+		},
+		{
+			name:   "significant octets",
+			routes: "10.1.128.0/17 via 192.168.0.254;10.1.2.3/32 via 192.168.0.254;0.0.0.0/0 via 192.168.0.254",
+			want: []byte{
+				17, 10, 1, 128, 192, 168, 0, 254,
+				32, 10, 1, 2, 3, 192, 168, 0, 254,
+				0, 192, 168, 0, 254,
+			},
+		},
+		{
+			name:   "connected",
+			routes: "172.16.0.0/12 connected",
+			want:   []byte{12, 172, 16, 0, 0, 0, 0},
+		},
+		{
+			name:   "IPv6 routes skipped",
+			routes: "2001:db8::/32 via 2001:db8::1;10.0.0.0/8 connected",
+			want:   []byte{8, 10, 0, 0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		routes, err := parseRoutes(tt.routes)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		l := &dhcpLease{gateway: net.ParseIP(tt.gateway), routes: routes}
+		if got := l.classlessRoutes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: classlessRoutes() = %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDHCPLease(t *testing.T) {
+	config := &networkConfiguration{
+		SubnetsIPv4: []*subnet{
+			{Pool: mustParseCIDR(t, "192.168.0.0/24"), Gateway: net.ParseIP("192.168.0.254")},
+			{Pool: mustParseCIDR(t, "10.0.0.0/24")},
+		},
+		DHCPDNS: []net.IP{net.ParseIP("192.168.0.53")},
+		Routes:  []*StaticRoute{{Destination: mustParseCIDR(t, "10.1.0.0/16"), RouteType: types.CONNECTED}},
+	}
+
+	tests := []struct {
+		name     string
+		ep       *bridgeEndpoint
+		serverID string // empty if the endpoint gets no lease
+	}{
+		{name: "no address", ep: &bridgeEndpoint{}},
+		{name: "gateway", ep: &bridgeEndpoint{addr: mustParseCIDR(t, "192.168.0.2/24")}, serverID: "192.168.0.254"},
+		{name: "no gateway", ep: &bridgeEndpoint{addr: mustParseCIDR(t, "10.0.0.2/24")}, serverID: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		lease := config.dhcpLease(tt.ep)
+		if tt.serverID == "" {
+			if lease != nil {
+				t.Errorf("%s: expected no lease, got one for %s", tt.name, lease.addr)
+			}
+			continue
+		}
+		if lease == nil {
+			t.Errorf("%s: expected a lease", tt.name)
+			continue
+		}
+		if !lease.serverID.Equal(net.ParseIP(tt.serverID)) {
+			t.Errorf("%s: server ID = %s, expected %s", tt.name, lease.serverID, tt.serverID)
+		}
+		if len(lease.dns) != 1 || len(lease.routes) != 1 {
+			t.Errorf("%s: expected the DNS servers and routes of the network, got %v and %v", tt.name, lease.dns, lease.routes)
+		}
+	}
+
+	// Routes of the endpoint replace those of the network.
+	ep := &bridgeEndpoint{addr: mustParseCIDR(t, "192.168.0.2/24"), config: &endpointConfiguration{Routes: []*StaticRoute{}}}
+	if lease := config.dhcpLease(ep); len(lease.routes) != 0 {
+		t.Errorf("Expected the routes of the endpoint, got %v", lease.routes)
+	}
+}
@@ -1,9 +1,11 @@
 package l2bridge
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -12,15 +14,21 @@ type Driver struct {
 	bridge *bridgeDriver
 }
 
-func NewDriver() *Driver {
-	return &Driver{
-		bridge: NewBridgeDriver(nil),
+// NewDriver constructs a new driver, restoring any networks and endpoints persisted by a previous instance.
+// If config is nil, the default configuration is used.
+func NewDriver(config *Configuration) (*Driver, error) {
+	bridge := NewBridgeDriver(config)
+	switch bridge.config.Scope {
+	case "", network.LocalScope, network.GlobalScope:
+	default:
+		return nil, fmt.Errorf("invalid scope %q: must be %s or %s", bridge.config.Scope, network.LocalScope, network.GlobalScope)
 	}
-}
-
-var capabilities = &network.CapabilitiesResponse{
-	Scope:             network.LocalScope,
-	ConnectivityScope: network.LocalScope,
+	if err := bridge.configure(map[string]interface{}{netlabel.GenericData: bridge.config}); err != nil {
+		return nil, err
+	}
+	return &Driver{
+		bridge: bridge,
+	}, nil
 }
 
 // unwrap gives the pointed to value if the i is an non-nil pointer.
@@ -69,7 +77,15 @@ func logRequest(fname string, req interface{}, res interface{}, err error) {
 
 func (d *Driver) GetCapabilities() (res *network.CapabilitiesResponse, err error) {
 	defer func() { logRequest("GetCapabilities", nil, res, err) }()
-	return capabilities, nil
+
+	scope := d.bridge.config.Scope
+	if scope == "" {
+		scope = network.LocalScope
+	}
+	return &network.CapabilitiesResponse{
+		Scope:             scope,
+		ConnectivityScope: scope,
+	}, nil
 }
 
 func (d *Driver) CreateNetwork(req *network.CreateNetworkRequest) (err error) {
@@ -91,7 +107,11 @@ func (d *Driver) CreateNetwork(req *network.CreateNetworkRequest) (err error) {
 
 func (d *Driver) AllocateNetwork(req *network.AllocateNetworkRequest) (res *network.AllocateNetworkResponse, err error) {
 	defer func() { logRequest("AllocateNetwork", req, res, err) }()
-	return nil, types.NotImplementedErrorf("not implemented")
+	options, err := d.bridge.AllocateNetwork(req.NetworkID, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &network.AllocateNetworkResponse{Options: options}, nil
 }
 
 func (d *Driver) DeleteNetwork(req *network.DeleteNetworkRequest) (err error) {
@@ -101,7 +121,7 @@ func (d *Driver) DeleteNetwork(req *network.DeleteNetworkRequest) (err error) {
 
 func (d *Driver) FreeNetwork(req *network.FreeNetworkRequest) (err error) {
 	defer func() { logRequest("FreeNetwork", req, nil, err) }()
-	return types.NotImplementedErrorf("not implemented")
+	return d.bridge.FreeNetwork(req.NetworkID)
 }
 
 func (d *Driver) CreateEndpoint(req *network.CreateEndpointRequest) (res *network.CreateEndpointResponse, err error) {
@@ -156,17 +176,16 @@ func (d *Driver) DiscoverDelete(notif *network.DiscoveryNotification) (err error
 	return nil
 }
 
-// ProgramExternalConnectivity is called after Join for non-internal networks to give external network access.
-// Although this driver does not support external connectivity, it does not return an error because libnetwork
-// will fail the endpoint initialization if any error is returned.
+// ProgramExternalConnectivity is called after Join for non-internal networks to give external network access. Only
+// networks with a gateway address on the bridge are given access through the host. On others, it is up to the
+// gateway of the network, and no error is returned because libnetwork would fail the endpoint initialization.
 func (d *Driver) ProgramExternalConnectivity(req *network.ProgramExternalConnectivityRequest) (err error) {
 	defer func() { logRequest("ProgramExternalConnectivity", req, nil, err) }()
-	return nil
+	return d.bridge.ProgramExternalConnectivity(req.NetworkID, req.EndpointID, req.Options)
 }
 
-// RevokeExternalConnectivity is called bedore Leave when tearing down an endpoint to remove up external network access.
-// As for ProgramExternalConnectivity, we return no error here, bt take no action.
+// RevokeExternalConnectivity is called before Leave when tearing down an endpoint to remove external network access.
 func (d *Driver) RevokeExternalConnectivity(req *network.RevokeExternalConnectivityRequest) (err error) {
 	defer func() { logRequest("RevokeExternalConnectivity", req, nil, err) }()
-	return nil
+	return d.bridge.RevokeExternalConnectivity(req.NetworkID, req.EndpointID)
 }
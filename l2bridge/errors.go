@@ -34,6 +34,16 @@ func (eitp *ErrInvalidTransportPortsOption) Error() string {
 // BadRequest denotes the type of this error
 func (eitp *ErrInvalidTransportPortsOption) BadRequest() {}
 
+// ErrInvalidPortBindingsOption is returned when the driver recieves a request with a PortMap key that could not be decoded.
+type ErrInvalidPortBindingsOption struct{}
+
+func (eipb *ErrInvalidPortBindingsOption) Error() string {
+	return "specified port bindings could not be decoded"
+}
+
+// BadRequest denotes the type of this error
+func (eipb *ErrInvalidPortBindingsOption) BadRequest() {}
+
 // ErrInvalidGateway is returned when the user provided default gateway (v4/v6) is not not valid.
 type ErrInvalidGateway struct{}
 
@@ -54,6 +64,26 @@ func (eim ErrInvalidMtu) Error() string {
 // BadRequest denotes the type of this error
 func (eim ErrInvalidMtu) BadRequest() {}
 
+// ErrInvalidVlan is returned when the user provided VLAN configuration is not valid.
+type ErrInvalidVlan string
+
+func (eiv ErrInvalidVlan) Error() string {
+	return fmt.Sprintf("invalid VLAN configuration: %s", string(eiv))
+}
+
+// BadRequest denotes the type of this error
+func (eiv ErrInvalidVlan) BadRequest() {}
+
+// ErrInvalidVxlan is returned when the user provided VXLAN configuration is not valid.
+type ErrInvalidVxlan string
+
+func (eiv ErrInvalidVxlan) Error() string {
+	return fmt.Sprintf("invalid VXLAN configuration: %s", string(eiv))
+}
+
+// BadRequest denotes the type of this error
+func (eiv ErrInvalidVxlan) BadRequest() {}
+
 // InvalidNetworkIDError is returned when the passed
 // network id for an existing network is not a known id.
 type InvalidNetworkIDError string
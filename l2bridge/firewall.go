@@ -0,0 +1,404 @@
+package l2bridge
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FirewallIPTables selects the iptables firewall backend.
+	FirewallIPTables = "iptables"
+	// FirewallNftables selects the native nftables firewall backend.
+	FirewallNftables = "nftables"
+)
+
+// firewall programs the host firewall rules of l2bridge networks.
+type firewall interface {
+	// dropForwarding drops forwarded traffic which no rule accepts: all of it with iptables, which sets the policy of
+	// FORWARD as Docker does, and that of the l2bridge bridges with nftables. It is called when the driver enables IP
+	// forwarding, so that the host does not start routing traffic it did not route before.
+	dropForwarding() error
+	// setLocalForwarding adds or removes a rule to allow traffic to pass through the bridge locally depending on
+	// whether enable is true or false respectively.
+	setLocalForwarding(bridgeName string, enable bool) error
+	// setPeerForwarding adds or removes rules to allow traffic to be forwarded from one bridge to another, and replies
+	// to be forwarded back, depending on whether enable is true or false respectively.
+	setPeerForwarding(fromBridge, toBridge string, enable bool) error
+	// setMasquerade adds or removes a rule to masquerade traffic from the pool of a bridge which leaves the host
+	// through another interface, depending on whether enable is true or false respectively.
+	setMasquerade(bridgeName string, pool *net.IPNet, enable bool) error
+	// setExternalForwarding adds or removes rules to allow traffic from an address on a bridge to be forwarded out of
+	// other interfaces, and replies to be forwarded back, depending on whether enable is true or false respectively.
+	setExternalForwarding(bridgeName string, addr net.IP, enable bool) error
+	// setPortMapping adds or removes rules to forward traffic to the host port of the binding to the container address
+	// and port of the binding on a bridge, depending on whether enable is true or false respectively.
+	setPortMapping(bridgeName string, binding types.PortBinding, enable bool) error
+	// onReloaded registers a function to be called when the rules have been flushed by a firewall reload.
+	onReloaded(callback func())
+	// supportsIPv6 returns whether the backend programs IPv6 rules.
+	supportsIPv6() bool
+}
+
+// newFirewall returns the firewall backend of the given name. An empty name selects iptables when the iptables command
+// is available, and nftables otherwise.
+func newFirewall(backend string) (firewall, error) {
+	if backend == "" {
+		backend = detectFirewall()
+		logrus.Infof("Using the %s firewall backend", backend)
+	}
+
+	switch backend {
+	case FirewallIPTables:
+		return newIPTablesFirewall()
+	case FirewallNftables:
+		return newNftablesFirewall()
+	default:
+		return nil, fmt.Errorf("unknown firewall backend %q: must be %s or %s", backend, FirewallIPTables, FirewallNftables)
+	}
+}
+
+func detectFirewall() string {
+	if _, err := exec.LookPath("iptables"); err != nil {
+		if _, err := exec.LookPath("nft"); err == nil {
+			return FirewallNftables
+		}
+	}
+	return FirewallIPTables
+}
+
+const (
+	// iptablesChain is the chain, in both the filter and the nat table, holding the rules of all l2bridge networks.
+	iptablesChain = "L2BRIDGE"
+	// iptablesDNATChain is the chain of the nat table holding the port mappings of all l2bridge networks.
+	iptablesDNATChain = "L2BRIDGE-DNAT"
+	// dockerUserChain is the chain in which Docker lets users place their own rules, ahead of its own.
+	dockerUserChain = "DOCKER-USER"
+)
+
+// iptablesFirewall programs rules in the L2BRIDGE chain of the iptables filter table, which is jumped to from FORWARD
+// right after DOCKER-USER, in the L2BRIDGE chain of the nat table, which is jumped to from POSTROUTING, and in the
+// L2BRIDGE-DNAT chain of the nat table, which is jumped to from PREROUTING and OUTPUT for local addresses. The chains
+// are flushed when the driver starts, and removed along with the jumps once no bridge has rules left in them, so that
+// no rules are leaked by a crash. Only IPv4 rules are programmed.
+type iptablesFirewall struct {
+	bridges map[string]bool // key: bridge name
+	sync.Mutex
+}
+
+// newIPTablesFirewall returns an iptables backend, removing any rules left over by a previous run. Rules are then
+// re-programmed as networks are restored.
+func newIPTablesFirewall() (*iptablesFirewall, error) {
+	fw := &iptablesFirewall{bridges: make(map[string]bool)}
+	if err := fw.teardown(); err != nil {
+		return nil, fmt.Errorf("failed to flush iptables chain %s: %v", iptablesChain, err)
+	}
+	return fw, nil
+}
+
+// natJumps are the jumps to the chains of the nat table. The jump from FORWARD is placed by ensureJump.
+var natJumps = []struct {
+	table iptables.Table
+	chain string
+	rule  []string
+}{
+	{iptables.Nat, "POSTROUTING", []string{"-j", iptablesChain}},
+	{iptables.Nat, "PREROUTING", []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", iptablesDNATChain}},
+	{iptables.Nat, "OUTPUT", []string{"!", "-d", "127.0.0.0/8", "-m", "addrtype", "--dst-type", "LOCAL", "-j", iptablesDNATChain}},
+}
+
+// ensureChain creates the chains of the driver, if missing after a start or a firewall reload, and places the jumps
+// to them.
+func (fw *iptablesFirewall) ensureChain() error {
+	chains := []struct {
+		table iptables.Table
+		name  string
+	}{
+		{iptables.Filter, iptablesChain},
+		{iptables.Nat, iptablesChain},
+		{iptables.Nat, iptablesDNATChain},
+	}
+	for _, c := range chains {
+		if !iptables.ExistChain(c.name, c.table) {
+			if _, err := iptables.NewChain(c.name, c.table, false); err != nil {
+				return fmt.Errorf("failed to create %s chain in %s table: %v", c.name, c.table, err)
+			}
+		}
+	}
+	if err := iptables.AddReturnRule(iptablesChain); err != nil {
+		return err
+	}
+
+	for _, jump := range natJumps {
+		if !iptables.Exists(jump.table, jump.chain, jump.rule...) {
+			if err := iptables.ProgramRule(jump.table, jump.chain, iptables.Append, jump.rule); err != nil {
+				return fmt.Errorf("failed to add jump from %s: %v", jump.chain, err)
+			}
+		}
+	}
+	return fw.ensureJump()
+}
+
+// ensureJump places the jump from FORWARD to L2BRIDGE right after the jump to DOCKER-USER, so that user rules take
+// precedence, or first if there is no DOCKER-USER chain. Docker inserts its own jumps at the top of FORWARD, so the
+// jump is checked each time rules are programmed. It is only moved when out of place, the new jump being inserted
+// before the old one is removed so that traffic of the networks is never left to the policy of FORWARD.
+func (fw *iptablesFirewall) ensureJump() error {
+	rules, err := listForwardRules()
+	if err != nil {
+		return err
+	}
+	pos, jumps := jumpPositions(rules)
+	if len(jumps) == 1 && jumps[0] == pos {
+		return nil
+	}
+
+	if out, err := iptables.Raw("-t", string(iptables.Filter), "-I", "FORWARD", strconv.Itoa(pos), "-j", iptablesChain); err != nil {
+		return fmt.Errorf("failed to add jump to %s: %v", iptablesChain, err)
+	} else if len(out) != 0 {
+		return fmt.Errorf("failed to add jump to %s: %s", iptablesChain, out)
+	}
+	if len(jumps) == 0 {
+		return nil
+	}
+
+	// Remove the jumps out of place, from the bottom up so that the positions of the others hold.
+	if rules, err = listForwardRules(); err != nil {
+		return err
+	}
+	_, jumps = jumpPositions(rules)
+	for i := len(jumps) - 1; i >= 0; i-- {
+		if jumps[i] == pos {
+			continue
+		}
+		if out, err := iptables.Raw("-t", string(iptables.Filter), "-D", "FORWARD", strconv.Itoa(jumps[i])); err != nil {
+			return fmt.Errorf("failed to remove jump to %s: %v", iptablesChain, err)
+		} else if len(out) != 0 {
+			return fmt.Errorf("failed to remove jump to %s: %s", iptablesChain, out)
+		}
+	}
+	return nil
+}
+
+// listForwardRules returns the rules of the FORWARD chain of the filter table.
+func listForwardRules() ([]string, error) {
+	out, err := iptables.Raw("-t", string(iptables.Filter), "-S", "FORWARD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list FORWARD chain: %v", err)
+	}
+	return forwardRules(out), nil
+}
+
+// jumpPositions returns the position, counting from 1 as iptables does, at which the jump to L2BRIDGE belongs in the
+// given FORWARD rules, and the positions of the jumps to L2BRIDGE found in them.
+func jumpPositions(rules []string) (pos int, jumps []int) {
+	pos = 1
+	for i, rule := range rules {
+		switch rule {
+		case "-A FORWARD -j " + dockerUserChain:
+			if pos == 1 {
+				pos = i + 2
+			}
+		case "-A FORWARD -j " + iptablesChain:
+			jumps = append(jumps, i+1)
+		}
+	}
+	return pos, jumps
+}
+
+// forwardRules returns the rules, rather than the policy, from the output of iptables -S FORWARD.
+func forwardRules(out []byte) []string {
+	var rules []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "-A ") {
+			rules = append(rules, strings.TrimSpace(line))
+		}
+	}
+	return rules
+}
+
+// removeRule removes all copies of the rule from the given chain.
+func removeRule(table iptables.Table, chain string, rule []string) error {
+	for iptables.Exists(table, chain, rule...) {
+		if err := iptables.ProgramRule(table, chain, iptables.Delete, rule); err != nil {
+			return fmt.Errorf("failed to remove rule from %s: %v", chain, err)
+		}
+	}
+	return nil
+}
+
+// teardown removes the jumps to the chains of the driver and the chains themselves, with all rules in them.
+func (fw *iptablesFirewall) teardown() error {
+	if err := removeRule(iptables.Filter, "FORWARD", []string{"-j", iptablesChain}); err != nil {
+		return err
+	}
+	for _, jump := range natJumps {
+		if err := removeRule(jump.table, jump.chain, jump.rule); err != nil {
+			return err
+		}
+	}
+	if err := iptables.RemoveExistingChain(iptablesChain, iptables.Filter); err != nil {
+		return err
+	}
+	if err := iptables.RemoveExistingChain(iptablesChain, iptables.Nat); err != nil {
+		return err
+	}
+	return iptables.RemoveExistingChain(iptablesDNATChain, iptables.Nat)
+}
+
+// programRules inserts the rules into the given chain of the driver, skipping those which exist, or deletes those
+// which exist, depending on whether enable is true or false respectively.
+func (fw *iptablesFirewall) programRules(table iptables.Table, chain string, rules [][]string, enable bool) error {
+	if enable {
+		if err := fw.ensureChain(); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range rules {
+		exists := iptables.Exists(table, chain, rule...)
+		switch {
+		case enable && !exists:
+			if err := iptables.ProgramRule(table, chain, iptables.Insert, rule); err != nil {
+				return err
+			}
+		case !enable && exists:
+			if err := iptables.ProgramRule(table, chain, iptables.Delete, rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fw *iptablesFirewall) dropForwarding() error {
+	if err := iptables.SetDefaultPolicy(iptables.Filter, "FORWARD", iptables.Drop); err != nil {
+		return err
+	}
+	iptables.OnReloaded(func() {
+		logrus.Debug("Setting the default DROP policy on firewall reload")
+		if err := iptables.SetDefaultPolicy(iptables.Filter, "FORWARD", iptables.Drop); err != nil {
+			logrus.Warnf("Settig the default DROP policy on firewall reload failed, %v", err)
+		}
+	})
+	return nil
+}
+
+func (fw *iptablesFirewall) setLocalForwarding(bridgeIface string, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	rules := [][]string{{"-i", bridgeIface, "-o", bridgeIface, "-j", "ACCEPT"}}
+
+	if enable {
+		if err := fw.programRules(iptables.Filter, iptablesChain, rules, true); err != nil {
+			return fmt.Errorf("unable to setup bridge forwarding rule: %v", err)
+		}
+		fw.bridges[bridgeIface] = true
+		return nil
+	}
+
+	if err := fw.programRules(iptables.Filter, iptablesChain, rules, false); err != nil {
+		return fmt.Errorf("unable to cleanup bridge forwarding rule: %v", err)
+	}
+	delete(fw.bridges, bridgeIface)
+	if len(fw.bridges) == 0 {
+		if err := fw.teardown(); err != nil {
+			return fmt.Errorf("unable to cleanup %s chain: %v", iptablesChain, err)
+		}
+	}
+	return nil
+}
+
+func (fw *iptablesFirewall) setPeerForwarding(fromBridge, toBridge string, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	rules := [][]string{
+		{"-i", fromBridge, "-o", toBridge, "-j", "ACCEPT"},
+		{"-i", toBridge, "-o", fromBridge, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	if err := fw.programRules(iptables.Filter, iptablesChain, rules, enable); err != nil {
+		return fmt.Errorf("unable to program peer forwarding rules: %v", err)
+	}
+	return nil
+}
+
+func (fw *iptablesFirewall) setMasquerade(bridgeName string, pool *net.IPNet, enable bool) error {
+	if pool.IP.To4() == nil {
+		return nil
+	}
+
+	fw.Lock()
+	defer fw.Unlock()
+
+	rules := [][]string{{"-s", pool.String(), "!", "-o", bridgeName, "-j", "MASQUERADE"}}
+	if err := fw.programRules(iptables.Nat, iptablesChain, rules, enable); err != nil {
+		return fmt.Errorf("unable to program masquerade rule: %v", err)
+	}
+	return nil
+}
+
+func (fw *iptablesFirewall) setExternalForwarding(bridgeName string, addr net.IP, enable bool) error {
+	if addr.To4() == nil {
+		return nil
+	}
+
+	fw.Lock()
+	defer fw.Unlock()
+
+	rules := [][]string{
+		{"-i", bridgeName, "-s", addr.String(), "!", "-o", bridgeName, "-j", "ACCEPT"},
+		{"-o", bridgeName, "-d", addr.String(), "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	if err := fw.programRules(iptables.Filter, iptablesChain, rules, enable); err != nil {
+		return fmt.Errorf("unable to program external forwarding rules: %v", err)
+	}
+	return nil
+}
+
+func (fw *iptablesFirewall) setPortMapping(bridgeName string, binding types.PortBinding, enable bool) error {
+	if binding.IP.To4() == nil {
+		return nil
+	}
+
+	fw.Lock()
+	defer fw.Unlock()
+
+	var (
+		proto     = binding.Proto.String()
+		hostPort  = strconv.Itoa(int(binding.HostPort))
+		port      = strconv.Itoa(int(binding.Port))
+		container = net.JoinHostPort(binding.IP.String(), port)
+	)
+
+	dnat := []string{"-p", proto}
+	if binding.HostIP != nil && !binding.HostIP.IsUnspecified() {
+		dnat = append(dnat, "-d", binding.HostIP.String())
+	}
+	dnat = append(dnat, "--dport", hostPort, "!", "-i", bridgeName, "-j", "DNAT", "--to-destination", container)
+	accept := []string{"-d", binding.IP.String(), "!", "-i", bridgeName, "-o", bridgeName, "-p", proto, "--dport", port, "-j", "ACCEPT"}
+
+	if err := fw.programRules(iptables.Nat, iptablesDNATChain, [][]string{dnat}, enable); err != nil {
+		return fmt.Errorf("unable to program port mapping: %v", err)
+	}
+	if err := fw.programRules(iptables.Filter, iptablesChain, [][]string{accept}, enable); err != nil {
+		return fmt.Errorf("unable to program port mapping: %v", err)
+	}
+	return nil
+}
+
+func (fw *iptablesFirewall) supportsIPv6() bool {
+	return false
+}
+
+func (fw *iptablesFirewall) onReloaded(callback func()) {
+	iptables.OnReloaded(callback)
+}
@@ -0,0 +1,327 @@
+package l2bridge
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// nftTable is the table, in the inet family, holding all rules of the driver.
+	nftTable = "l2bridge"
+	// nftBridgeChainPrefix is prepended to the bridge name to form the chain holding the rules of a bridge.
+	nftBridgeChainPrefix = "br-"
+)
+
+// nftablesFirewall programs rules in a table of its own. A base chain on the forward hook dispatches traffic from each
+// bridge to a chain holding the rules of that bridge. The table is rendered from the state of the backend and replaced
+// as a whole on every change, in a single transaction, so that programming it is idempotent.
+type nftablesFirewall struct {
+	drop       bool                       // whether forwarded traffic of the bridges which no rule accepts is dropped
+	bridges    map[string]bool            // key: bridge name
+	peers      map[string]map[string]bool // key: bridge name, value: set of bridges it may forward to
+	masquerade map[nftAddrRule]bool       // pools masqueraded on their way out of the host
+	external   map[nftAddrRule]bool       // addresses allowed to be forwarded out of the host
+	ports      map[nftPortMapping]bool    // host ports forwarded to containers
+	sync.Mutex
+}
+
+// nftPortMapping is a port binding of a container on a bridge.
+type nftPortMapping struct {
+	bridge, proto  string
+	hostIP, ip     string
+	hostPort, port uint16
+	ipv6           bool
+}
+
+func newNftPortMapping(bridgeName string, binding types.PortBinding) nftPortMapping {
+	pm := nftPortMapping{
+		bridge:   bridgeName,
+		proto:    binding.Proto.String(),
+		ip:       binding.IP.String(),
+		hostPort: binding.HostPort,
+		port:     binding.Port,
+		ipv6:     binding.IP.To4() == nil,
+	}
+	if binding.HostIP != nil && !binding.HostIP.IsUnspecified() {
+		pm.hostIP = binding.HostIP.String()
+	}
+	return pm
+}
+
+// rules returns the DNAT rule, to be added to the prerouting and output chains, and the rule accepting the forwarded
+// traffic, to be added to the forward chain.
+func (pm nftPortMapping) rules() (dnat, accept string) {
+	family, to := "ip", fmt.Sprintf("%s:%d", pm.ip, pm.port)
+	if pm.ipv6 {
+		family, to = "ip6", fmt.Sprintf("[%s]:%d", pm.ip, pm.port)
+	}
+
+	dnat = "fib daddr type local "
+	if pm.hostIP != "" {
+		dnat += fmt.Sprintf("%s daddr %s ", family, pm.hostIP)
+	}
+	dnat += fmt.Sprintf("%s dport %d dnat %s to %s", pm.proto, pm.hostPort, family, to)
+	accept = fmt.Sprintf("iifname != %q oifname %q %s daddr %s %s dport %d accept", pm.bridge, pm.bridge, family, pm.ip, pm.proto, pm.port)
+	return dnat, accept
+}
+
+func (pm nftPortMapping) String() string {
+	return fmt.Sprintf("%s/%s/%s/%d/%s/%d", pm.bridge, pm.proto, pm.hostIP, pm.hostPort, pm.ip, pm.port)
+}
+
+// nftAddrRule is a rule matching an address, or a prefix, on a bridge.
+type nftAddrRule struct {
+	bridge, addr string
+	ipv6         bool
+}
+
+func newNftAddrRule(bridgeName string, ip net.IP, addr string) nftAddrRule {
+	return nftAddrRule{bridge: bridgeName, addr: addr, ipv6: ip.To4() == nil}
+}
+
+// family returns the nftables protocol of the address, used in address matches.
+func (r nftAddrRule) family() string {
+	if r.ipv6 {
+		return "ip6"
+	}
+	return "ip"
+}
+
+func sortedAddrRules(set map[nftAddrRule]bool) []nftAddrRule {
+	rules := make([]nftAddrRule, 0, len(set))
+	for rule := range set {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].bridge != rules[j].bridge {
+			return rules[i].bridge < rules[j].bridge
+		}
+		return rules[i].addr < rules[j].addr
+	})
+	return rules
+}
+
+// newNftablesFirewall returns an nftables backend, replacing any table left over by a previous run. Rules are then
+// re-programmed as networks are restored.
+func newNftablesFirewall() (*nftablesFirewall, error) {
+	fw := &nftablesFirewall{
+		bridges:    make(map[string]bool),
+		peers:      make(map[string]map[string]bool),
+		masquerade: make(map[nftAddrRule]bool),
+		external:   make(map[nftAddrRule]bool),
+		ports:      make(map[nftPortMapping]bool),
+	}
+	if err := fw.apply(); err != nil {
+		return nil, fmt.Errorf("failed to initialize nftables table %s: %v", nftTable, err)
+	}
+	return fw, nil
+}
+
+func nftChain(bridgeName string) string {
+	return nftBridgeChainPrefix + bridgeName
+}
+
+// nftScript runs the commands of the script in a single transaction.
+func nftScript(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("nft failed: %v: %s", err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// apply replaces the table with one rendered from the state of the backend. It must be called with the lock held.
+func (fw *nftablesFirewall) apply() error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "add table inet %s\n", nftTable)
+	fmt.Fprintf(&b, "delete table inet %s\n", nftTable)
+	fmt.Fprintf(&b, "add table inet %s\n", nftTable)
+	fmt.Fprintf(&b, "add chain inet %s forward { type filter hook forward priority 0; }\n", nftTable)
+
+	for _, name := range sortedKeys(fw.bridges) {
+		chain := nftChain(name)
+		fmt.Fprintf(&b, "add chain inet %s %s\n", nftTable, chain)
+		fmt.Fprintf(&b, "add rule inet %s forward iifname %q jump %s\n", nftTable, name, chain)
+		fmt.Fprintf(&b, "add rule inet %s %s oifname %q accept\n", nftTable, chain, name)
+	}
+
+	// Peer rules are placed in the chain of either bridge: the one of the origin for requests, and the one of the
+	// destination for replies.
+	for _, from := range sortedKeys(fw.bridges) {
+		for _, to := range sortedKeys(fw.peers[from]) {
+			if !fw.bridges[to] {
+				continue
+			}
+			fmt.Fprintf(&b, "add rule inet %s %s oifname %q accept\n", nftTable, nftChain(from), to)
+			fmt.Fprintf(&b, "add rule inet %s %s oifname %q ct state established,related accept\n", nftTable, nftChain(to), from)
+		}
+	}
+
+	// Replies to external traffic come in through other interfaces, so they are matched in the base chain.
+	for _, r := range sortedAddrRules(fw.external) {
+		if !fw.bridges[r.bridge] {
+			continue
+		}
+		fmt.Fprintf(&b, "add rule inet %s %s %s saddr %s oifname != %q accept\n", nftTable, nftChain(r.bridge), r.family(), r.addr, r.bridge)
+		fmt.Fprintf(&b, "add rule inet %s forward oifname %q %s daddr %s ct state established,related accept\n", nftTable, r.bridge, r.family(), r.addr)
+	}
+
+	if len(fw.ports) > 0 {
+		mappings := make([]nftPortMapping, 0, len(fw.ports))
+		for pm := range fw.ports {
+			if fw.bridges[pm.bridge] {
+				mappings = append(mappings, pm)
+			}
+		}
+		sort.Slice(mappings, func(i, j int) bool { return mappings[i].String() < mappings[j].String() })
+
+		fmt.Fprintf(&b, "add chain inet %s prerouting { type nat hook prerouting priority -100; }\n", nftTable)
+		fmt.Fprintf(&b, "add chain inet %s output { type nat hook output priority -100; }\n", nftTable)
+		for _, pm := range mappings {
+			dnat, accept := pm.rules()
+			fmt.Fprintf(&b, "add rule inet %s prerouting iifname != %q %s\n", nftTable, pm.bridge, dnat)
+			fmt.Fprintf(&b, "add rule inet %s output %s\n", nftTable, dnat)
+			fmt.Fprintf(&b, "add rule inet %s forward %s\n", nftTable, accept)
+		}
+	}
+
+	// A drop in a base chain is final, whatever other tables accept, so unlike the FORWARD policy of iptables it only
+	// applies to the bridges of the driver, and comes after every rule accepting their traffic.
+	if fw.drop {
+		for _, name := range sortedKeys(fw.bridges) {
+			fmt.Fprintf(&b, "add rule inet %s forward iifname %q drop\n", nftTable, name)
+			fmt.Fprintf(&b, "add rule inet %s forward oifname %q drop\n", nftTable, name)
+		}
+	}
+
+	if len(fw.masquerade) > 0 {
+		fmt.Fprintf(&b, "add chain inet %s postrouting { type nat hook postrouting priority 100; }\n", nftTable)
+		for _, r := range sortedAddrRules(fw.masquerade) {
+			fmt.Fprintf(&b, "add rule inet %s postrouting %s saddr %s oifname != %q masquerade\n", nftTable, r.family(), r.addr, r.bridge)
+		}
+	}
+	return nftScript(b.String())
+}
+
+func (fw *nftablesFirewall) dropForwarding() error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	fw.drop = true
+	return fw.apply()
+}
+
+func (fw *nftablesFirewall) setLocalForwarding(bridgeName string, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	if enable {
+		fw.bridges[bridgeName] = true
+		if err := fw.apply(); err != nil {
+			return fmt.Errorf("unable to setup bridge forwarding rule: %v", err)
+		}
+		return nil
+	}
+
+	delete(fw.bridges, bridgeName)
+	if err := fw.apply(); err != nil {
+		return fmt.Errorf("unable to cleanup bridge forwarding rule: %v", err)
+	}
+	return nil
+}
+
+func (fw *nftablesFirewall) setPeerForwarding(fromBridge, toBridge string, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	if enable {
+		if fw.peers[fromBridge] == nil {
+			fw.peers[fromBridge] = make(map[string]bool)
+		}
+		fw.peers[fromBridge][toBridge] = true
+		if err := fw.apply(); err != nil {
+			return fmt.Errorf("unable to setup peer forwarding rule: %v", err)
+		}
+		return nil
+	}
+
+	delete(fw.peers[fromBridge], toBridge)
+	if err := fw.apply(); err != nil {
+		return fmt.Errorf("unable to cleanup peer forwarding rule: %v", err)
+	}
+	return nil
+}
+
+func (fw *nftablesFirewall) setMasquerade(bridgeName string, pool *net.IPNet, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	rule := newNftAddrRule(bridgeName, pool.IP, pool.String())
+	if enable {
+		fw.masquerade[rule] = true
+	} else {
+		delete(fw.masquerade, rule)
+	}
+	if err := fw.apply(); err != nil {
+		return fmt.Errorf("unable to program masquerade rule: %v", err)
+	}
+	return nil
+}
+
+func (fw *nftablesFirewall) setExternalForwarding(bridgeName string, addr net.IP, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	rule := newNftAddrRule(bridgeName, addr, addr.String())
+	if enable {
+		fw.external[rule] = true
+	} else {
+		delete(fw.external, rule)
+	}
+	if err := fw.apply(); err != nil {
+		return fmt.Errorf("unable to program external forwarding rules: %v", err)
+	}
+	return nil
+}
+
+func (fw *nftablesFirewall) setPortMapping(bridgeName string, binding types.PortBinding, enable bool) error {
+	fw.Lock()
+	defer fw.Unlock()
+
+	pm := newNftPortMapping(bridgeName, binding)
+	if enable {
+		fw.ports[pm] = true
+	} else {
+		delete(fw.ports, pm)
+	}
+	if err := fw.apply(); err != nil {
+		return fmt.Errorf("unable to program port mapping: %v", err)
+	}
+	return nil
+}
+
+// onReloaded does nothing, as firewalld does not flush tables it does not own.
+func (fw *nftablesFirewall) onReloaded(callback func()) {}
+
+func (fw *nftablesFirewall) supportsIPv6() bool {
+	return true
+}
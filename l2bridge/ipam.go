@@ -0,0 +1,311 @@
+package l2bridge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/docker/go-plugins-helpers/ipam"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ipamRequestAddressType is the option with which Docker marks the request of a network's gateway address.
+	ipamRequestAddressType = "RequestAddressType"
+	ipamGatewayAddressType = "com.docker.network.gateway"
+
+	ipamPoolStorePrefix = "pool"
+)
+
+// IPAMDriver is an IPAM driver which permits overlapping pools. Unlike the default IPAM driver, which allocates a
+// subnet once per address space, it hands every network a pool of its own, so that any number of l2bridge networks
+// can use the same subnet.
+type IPAMDriver struct {
+	pools map[string]*addressPool // key: pool id
+	store *localStore
+	sync.Mutex
+}
+
+// addressPool is the pool of a single network, and the addresses allocated from it.
+type addressPool struct {
+	id        string
+	pool      *net.IPNet
+	subPool   *net.IPNet
+	allocated map[string]bool // key: ip address
+}
+
+// NewIPAMDriver constructs a new IPAM driver, restoring the pools persisted by a previous instance. If config is nil,
+// the default configuration is used.
+func NewIPAMDriver(config *Configuration) (*IPAMDriver, error) {
+	if config == nil {
+		config = &Configuration{StateDir: DefaultStateDir}
+	}
+	d := &IPAMDriver{pools: make(map[string]*addressPool)}
+	if config.StateDir == "" {
+		return d, nil
+	}
+
+	store, err := newLocalStore(config.StateDir)
+	if err != nil {
+		return nil, types.InternalErrorf("l2bridge ipam driver failed to initialize data store: %v", err)
+	}
+	d.store = store
+
+	records, err := store.list(ipamPoolStorePrefix)
+	if err != nil {
+		return nil, types.InternalErrorf("failed to get address pools from store: %v", err)
+	}
+	for _, data := range records {
+		p := &addressPool{}
+		if err := json.Unmarshal(data, p); err != nil {
+			logrus.WithError(err).Warnf("Failed to decode address pool record from store")
+			continue
+		}
+		d.pools[p.id] = p
+		logrus.Debugf("Address pool %s restored", p.id)
+	}
+	return d, nil
+}
+
+func (d *IPAMDriver) GetCapabilities() (res *ipam.CapabilitiesResponse, err error) {
+	defer func() { logRequest("GetCapabilities", nil, res, err) }()
+	return &ipam.CapabilitiesResponse{RequiresMACAddress: false}, nil
+}
+
+func (d *IPAMDriver) GetDefaultAddressSpaces() (res *ipam.AddressSpacesResponse, err error) {
+	defer func() { logRequest("GetDefaultAddressSpaces", nil, res, err) }()
+	return &ipam.AddressSpacesResponse{
+		LocalDefaultAddressSpace:  "l2bridge-local",
+		GlobalDefaultAddressSpace: "l2bridge-global",
+	}, nil
+}
+
+func (d *IPAMDriver) RequestPool(req *ipam.RequestPoolRequest) (res *ipam.RequestPoolResponse, err error) {
+	defer func() { logRequest("RequestPool", req, res, err) }()
+
+	if req.Pool == "" {
+		return nil, types.BadRequestErrorf("l2bridge ipam driver requires a subnet")
+	}
+	_, pool, err := net.ParseCIDR(req.Pool)
+	if err != nil {
+		return nil, types.BadRequestErrorf("invalid pool %s: %v", req.Pool, err)
+	}
+	if (pool.IP.To4() == nil) != req.V6 {
+		return nil, types.BadRequestErrorf("pool %s is not of the requested address family", req.Pool)
+	}
+
+	p := &addressPool{pool: pool, allocated: make(map[string]bool)}
+	if req.SubPool != "" {
+		if _, p.subPool, err = net.ParseCIDR(req.SubPool); err != nil {
+			return nil, types.BadRequestErrorf("invalid sub-pool %s: %v", req.SubPool, err)
+		}
+		if !pool.Contains(p.subPool.IP) {
+			return nil, types.BadRequestErrorf("sub-pool %s is not part of pool %s", req.SubPool, req.Pool)
+		}
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	p.id = fmt.Sprintf("%s/%s", hex.EncodeToString(id), pool)
+
+	if err := d.update(p); err != nil {
+		return nil, err
+	}
+	return &ipam.RequestPoolResponse{PoolID: p.id, Pool: pool.String()}, nil
+}
+
+func (d *IPAMDriver) ReleasePool(req *ipam.ReleasePoolRequest) (err error) {
+	defer func() { logRequest("ReleasePool", req, nil, err) }()
+
+	d.Lock()
+	p, ok := d.pools[req.PoolID]
+	delete(d.pools, req.PoolID)
+	d.Unlock()
+
+	if !ok {
+		return types.NotFoundErrorf("address pool %s not found", req.PoolID)
+	}
+	if d.store != nil {
+		return d.store.delete(p)
+	}
+	return nil
+}
+
+func (d *IPAMDriver) RequestAddress(req *ipam.RequestAddressRequest) (res *ipam.RequestAddressResponse, err error) {
+	defer func() { logRequest("RequestAddress", req, res, err) }()
+
+	var ip net.IP
+	if req.Address != "" {
+		if ip = net.ParseIP(req.Address); ip == nil {
+			return nil, types.BadRequestErrorf("invalid address %s", req.Address)
+		}
+	}
+
+	d.Lock()
+	p, ok := d.pools[req.PoolID]
+	if ok {
+		ip, err = p.allocate(ip, req.Options[ipamRequestAddressType] == ipamGatewayAddressType)
+	}
+	d.Unlock()
+
+	if !ok {
+		return nil, types.NotFoundErrorf("address pool %s not found", req.PoolID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.update(p); err != nil {
+		d.Lock()
+		delete(p.allocated, ip.String())
+		d.Unlock()
+		return nil, err
+	}
+	return &ipam.RequestAddressResponse{Address: (&net.IPNet{IP: ip, Mask: p.pool.Mask}).String()}, nil
+}
+
+func (d *IPAMDriver) ReleaseAddress(req *ipam.ReleaseAddressRequest) (err error) {
+	defer func() { logRequest("ReleaseAddress", req, nil, err) }()
+
+	ip := net.ParseIP(req.Address)
+	if ip == nil {
+		return types.BadRequestErrorf("invalid address %s", req.Address)
+	}
+
+	d.Lock()
+	p, ok := d.pools[req.PoolID]
+	if ok {
+		delete(p.allocated, ip.String())
+	}
+	d.Unlock()
+
+	if !ok {
+		return types.NotFoundErrorf("address pool %s not found", req.PoolID)
+	}
+	return d.update(p)
+}
+
+// update records the pool, and persists it if a store is configured. The lock is held while writing, as the pool may
+// otherwise change under the encoder.
+func (d *IPAMDriver) update(p *addressPool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.pools[p.id] = p
+	if d.store == nil {
+		return nil
+	}
+	if err := d.store.put(p); err != nil {
+		return fmt.Errorf("failed to update ipam store for pool %s: %v", p.id, err)
+	}
+	return nil
+}
+
+// allocate marks the address as allocated, and returns it. If no address is given, the first free one is picked, from
+// the sub-pool if there is one. The gateway takes the first address of the pool, as with the default IPAM driver. The
+// network address and, on IPv4, the broadcast address are never handed out.
+func (p *addressPool) allocate(ip net.IP, gateway bool) (net.IP, error) {
+	if ip != nil {
+		if !p.pool.Contains(ip) || !p.usable(ip) {
+			return nil, types.BadRequestErrorf("address %s is not usable in pool %s", ip, p.pool)
+		}
+		if p.allocated[ip.String()] {
+			return nil, types.ForbiddenErrorf("address %s is already allocated in pool %s", ip, p.pool)
+		}
+		p.allocated[ip.String()] = true
+		return ip, nil
+	}
+
+	r := p.pool
+	if p.subPool != nil && !gateway {
+		r = p.subPool
+	}
+	for ip := r.IP.Mask(r.Mask); r.Contains(ip); ip = nextIP(ip) {
+		if p.usable(ip) && !p.allocated[ip.String()] {
+			p.allocated[ip.String()] = true
+			return ip, nil
+		}
+	}
+	return nil, types.NoServiceErrorf("no free addresses left in pool %s", r)
+}
+
+func (p *addressPool) usable(ip net.IP) bool {
+	if ip.Equal(p.pool.IP.Mask(p.pool.Mask)) {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		broadcast, err := types.GetBroadcastIP(ip4, p.pool.Mask)
+		return err != nil || !ip4.Equal(broadcast)
+	}
+	return true
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := types.GetIPCopy(ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func (p *addressPool) storePrefix() string {
+	return ipamPoolStorePrefix
+}
+
+// storeID is the pool id, which contains the '/' of the subnet, made safe for use as a file name.
+func (p *addressPool) storeID() string {
+	return hex.EncodeToString([]byte(p.id))
+}
+
+func (p *addressPool) MarshalJSON() ([]byte, error) {
+	pMap := make(map[string]interface{})
+	pMap["ID"] = p.id
+	pMap["Pool"] = p.pool.String()
+	if p.subPool != nil {
+		pMap["SubPool"] = p.subPool.String()
+	}
+	allocated := make([]string, 0, len(p.allocated))
+	for ip := range p.allocated {
+		allocated = append(allocated, ip)
+	}
+	pMap["Allocated"] = allocated
+	return json.Marshal(pMap)
+}
+
+func (p *addressPool) UnmarshalJSON(b []byte) error {
+	var (
+		err  error
+		pMap map[string]interface{}
+	)
+
+	if err = json.Unmarshal(b, &pMap); err != nil {
+		return err
+	}
+
+	p.id = pMap["ID"].(string)
+	if p.pool, err = types.ParseCIDR(pMap["Pool"].(string)); err != nil {
+		return types.InternalErrorf("failed to decode address pool %s after json unmarshal: %v", pMap["Pool"], err)
+	}
+	if v, ok := pMap["SubPool"]; ok {
+		if p.subPool, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode address sub-pool %s after json unmarshal: %v", v.(string), err)
+		}
+	}
+	p.allocated = make(map[string]bool)
+	if v, ok := pMap["Allocated"]; ok {
+		for _, ip := range v.([]interface{}) {
+			p.allocated[ip.(string)] = true
+		}
+	}
+	return nil
+}
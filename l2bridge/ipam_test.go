@@ -0,0 +1,137 @@
+package l2bridge
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+)
+
+func newTestAddressPool(t *testing.T, pool, subPool string) *addressPool {
+	p := &addressPool{id: "pool", pool: mustParseCIDR(t, pool), allocated: make(map[string]bool)}
+	p.pool.IP = p.pool.IP.Mask(p.pool.Mask)
+	if subPool != "" {
+		p.subPool = mustParseCIDR(t, subPool)
+	}
+	return p
+}
+
+func TestAddressPoolAllocate(t *testing.T) {
+	tests := []struct {
+		name, pool, subPool string
+		gateway             bool
+		want                []string // addresses handed out by successive allocations
+		exhausted           bool     // whether the pool is exhausted after these
+	}{
+		{name: "first addresses", pool: "192.168.0.0/24", want: []string{"192.168.0.1", "192.168.0.2", "192.168.0.3"}},
+		{name: "no broadcast", pool: "192.168.0.0/30", want: []string{"192.168.0.1", "192.168.0.2"}, exhausted: true},
+		{name: "sub-pool", pool: "192.168.0.0/24", subPool: "192.168.0.128/25", want: []string{"192.168.0.128", "192.168.0.129"}},
+		{name: "gateway", pool: "192.168.0.0/24", subPool: "192.168.0.128/25", gateway: true, want: []string{"192.168.0.1"}},
+		{
+			name:      "sub-pool at the end",
+			pool:      "192.168.0.0/24",
+			subPool:   "192.168.0.252/30",
+			want:      []string{"192.168.0.252", "192.168.0.253", "192.168.0.254"},
+			exhausted: true,
+		},
+		{name: "IPv6", pool: "2001:db8::/126", want: []string{"2001:db8::1", "2001:db8::2", "2001:db8::3"}, exhausted: true},
+	}
+
+	for _, tt := range tests {
+		p := newTestAddressPool(t, tt.pool, tt.subPool)
+		for _, want := range tt.want {
+			ip, err := p.allocate(nil, tt.gateway)
+			if err != nil {
+				t.Fatalf("%s: allocate() failed: %v", tt.name, err)
+			}
+			if !ip.Equal(net.ParseIP(want)) {
+				t.Errorf("%s: allocate() = %s, expected %s", tt.name, ip, want)
+			}
+		}
+		if ip, err := p.allocate(nil, tt.gateway); tt.exhausted && err == nil {
+			t.Errorf("%s: allocate() = %s, expected the pool to be exhausted", tt.name, ip)
+		}
+	}
+}
+
+func TestAddressPoolAllocateAddress(t *testing.T) {
+	p := newTestAddressPool(t, "192.168.0.0/24", "192.168.0.128/25")
+
+	tests := []struct {
+		ip      string
+		wantErr bool
+	}{
+		{ip: "192.168.0.10"},
+		{ip: "192.168.0.10", wantErr: true},
+		{ip: "192.168.0.0", wantErr: true},
+		{ip: "192.168.0.255", wantErr: true},
+		{ip: "192.168.1.10", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		ip, err := p.allocate(net.ParseIP(tt.ip), false)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("allocate(%s) succeeded, expected an error", tt.ip)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("allocate(%s) failed: %v", tt.ip, err)
+			continue
+		}
+		if !ip.Equal(net.ParseIP(tt.ip)) {
+			t.Errorf("allocate(%s) = %s", tt.ip, ip)
+		}
+	}
+
+	// Addresses allocated explicitly are skipped when picking one.
+	p = newTestAddressPool(t, "192.168.0.0/24", "")
+	if _, err := p.allocate(net.ParseIP("192.168.0.1"), false); err != nil {
+		t.Fatal(err)
+	}
+	if ip, err := p.allocate(nil, false); err != nil || !ip.Equal(net.ParseIP("192.168.0.2")) {
+		t.Errorf("allocate() = %s, %v, expected 192.168.0.2", ip, err)
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "192.168.0.1", want: "192.168.0.2"},
+		{in: "192.168.0.255", want: "192.168.1.0"},
+		{in: "2001:db8::ffff", want: "2001:db8::1:0"},
+	}
+
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.in)
+		if got := nextIP(ip); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("nextIP(%s) = %s, expected %s", tt.in, got, tt.want)
+		}
+		if !ip.Equal(net.ParseIP(tt.in)) {
+			t.Errorf("nextIP(%s) modified its argument", tt.in)
+		}
+	}
+}
+
+func TestAddressPoolJSON(t *testing.T) {
+	p := newTestAddressPool(t, "192.168.0.0/24", "192.168.0.128/25")
+	p.allocated["192.168.0.1"] = true
+	p.allocated["192.168.0.128"] = true
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var q addressPool
+	if err := json.Unmarshal(b, &q); err != nil {
+		t.Fatal(err)
+	}
+
+	if q.id != p.id || q.pool.String() != p.pool.String() || q.subPool.String() != p.subPool.String() {
+		t.Errorf("Decoded pool %s %s/%s, expected %s %s/%s", q.id, q.pool, q.subPool, p.id, p.pool, p.subPool)
+	}
+	if len(q.allocated) != 2 || !q.allocated["192.168.0.1"] || !q.allocated["192.168.0.128"] {
+		t.Errorf("Decoded allocations %v, expected %v", q.allocated, p.allocated)
+	}
+}
@@ -0,0 +1,230 @@
+package l2bridge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/nategraf/l2bridge-driver/label"
+	"github.com/sirupsen/logrus"
+)
+
+// IPv6 address generation modes, named after the addr_gen_mode sysctl of Linux.
+const (
+	// AddrGenModeEUI64 forms the interface identifier from the MAC address, as SLAAC does (RFC 4291).
+	AddrGenModeEUI64 = "eui64"
+	// AddrGenModeStablePrivacy forms an opaque interface identifier, stable for a given endpoint and prefix (RFC 7217).
+	AddrGenModeStablePrivacy = "stable-privacy"
+	// AddrGenModeRandom picks a random free address.
+	AddrGenModeRandom = "random"
+
+	// addrGenModeMAC copies the MAC address into the last 48 bits, as earlier versions did. It is not selectable, but
+	// used by the default mode on prefixes too long for EUI-64.
+	addrGenModeMAC = "mac"
+
+	// ipv6AddrGenAttempts bounds the attempts at finding an address which is not in use.
+	ipv6AddrGenAttempts = 16
+
+	// ipv6SecretFile is the file of the state directory holding the secret key of stable privacy addresses.
+	ipv6SecretFile = "stable-privacy.key"
+	ipv6SecretLen  = 32
+)
+
+func validAddrGenMode(mode string) bool {
+	switch mode {
+	case "", AddrGenModeEUI64, AddrGenModeStablePrivacy, AddrGenModeRandom:
+		return true
+	}
+	return false
+}
+
+// generateIPv6 returns an address for an endpoint IPAM gave no IPv6 address to, from the first IPv6 subnet of the
+// network. Interface identifiers are 64 bits long, so EUI-64 and stable privacy addresses need a prefix of at most /64.
+// On longer prefixes, the default mode falls back to the address generation of earlier versions, which copies the MAC
+// address into the last 48 bits, or to random addresses when fewer host bits are left.
+func (n *bridgeNetwork) generateIPv6(config *networkConfiguration, ep *bridgeEndpoint) (*net.IPNet, error) {
+	pool := config.SubnetsIPv6[0].Pool
+	ones, bits := pool.Mask.Size()
+	if ones == bits {
+		return nil, types.ForbiddenErrorf("cannot generate an IPv6 address on network %v: it has no host bits", pool)
+	}
+
+	mode := config.IPv6AddrGenMode
+	if mode == "" {
+		mode = AddrGenModeEUI64
+	}
+	if ones > 64 {
+		switch {
+		case mode == AddrGenModeStablePrivacy:
+			return nil, types.ForbiddenErrorf("cannot generate an IPv6 address on network %v with %s %s: a prefix of at most /64 is needed", pool, label.IPv6AddrGenMode, mode)
+		case mode == AddrGenModeEUI64 && ones <= 80:
+			mode = addrGenModeMAC
+		case mode == AddrGenModeEUI64:
+			mode = AddrGenModeRandom
+		}
+	}
+
+	var secret []byte
+	if mode == AddrGenModeStablePrivacy {
+		var err error
+		if secret, err = n.driver.stablePrivacySecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; attempt < ipv6AddrGenAttempts; attempt++ {
+		var ip net.IP
+		switch mode {
+		case AddrGenModeStablePrivacy:
+			ip = stablePrivacyIPv6(pool, secret, config.ID, ep.id, attempt)
+		case AddrGenModeRandom:
+			var err error
+			if ip, err = randomIPv6(pool); err != nil {
+				return nil, err
+			}
+		case addrGenModeMAC:
+			ip = macIPv6(pool, ep.macAddress)
+		default:
+			ip = eui64IPv6(pool, ep.macAddress)
+		}
+
+		if !reservedIID(ip) && !n.ipv6InUse(config, ep, ip) {
+			return &net.IPNet{IP: ip, Mask: pool.Mask}, nil
+		}
+		// The address derived from the MAC address of an endpoint never changes, so there is no point in trying again.
+		if mode == AddrGenModeEUI64 || mode == addrGenModeMAC {
+			break
+		}
+	}
+	return nil, types.ForbiddenErrorf("cannot generate a free IPv6 address for endpoint %.7s on network %v", ep.id, pool)
+}
+
+// eui64IPv6 forms the address from the prefix and the modified EUI-64 identifier of the MAC address: the universal/local
+// bit flipped, and ff:fe inserted in the middle.
+func eui64IPv6(pool *net.IPNet, mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, pool.IP.To16())
+	ip[8] = mac[0] ^ 0x02
+	ip[9], ip[10] = mac[1], mac[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = mac[3], mac[4], mac[5]
+	return ip
+}
+
+// macIPv6 forms the address from the prefix and the MAC address in the last 48 bits, as earlier versions did.
+func macIPv6(pool *net.IPNet, mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, pool.IP.To16())
+	copy(ip[10:], mac)
+	return ip
+}
+
+// stablePrivacyIPv6 forms the address from the prefix and the first 64 bits of a pseudorandom function of the prefix,
+// the endpoint, the network and the attempt, as the DAD counter, keyed by the secret of the host, as per RFC 7217.
+func stablePrivacyIPv6(pool *net.IPNet, secret []byte, nid, eid string, attempt int) net.IP {
+	prefix := pool.IP.To16()
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(prefix[:8])
+	mac.Write([]byte(eid))
+	mac.Write([]byte(nid))
+	mac.Write([]byte{byte(attempt)})
+	rid := mac.Sum(nil)
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix)
+	copy(ip[8:], rid[:8])
+	return ip
+}
+
+// stablePrivacySecret returns the secret key of stable privacy addresses. It is generated once per host and kept in the
+// state directory, so that the addresses of endpoints remain the same across restarts. Without a state directory, a
+// new secret is used by each instance of the driver.
+func (d *bridgeDriver) stablePrivacySecret() ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.ipv6Secret != nil {
+		return d.ipv6Secret, nil
+	}
+
+	var path string
+	if d.config.StateDir != "" {
+		path = filepath.Join(d.config.StateDir, ipv6SecretFile)
+		secret, err := ioutil.ReadFile(path)
+		if err == nil && len(secret) == ipv6SecretLen {
+			d.ipv6Secret = secret
+			return secret, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read stable privacy secret: %v", err)
+		}
+		if err == nil {
+			logrus.Warnf("Ignoring stable privacy secret %s of invalid length %d, generating a new one", path, len(secret))
+		}
+	}
+
+	secret := make([]byte, ipv6SecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate stable privacy secret: %v", err)
+	}
+	if path != "" {
+		if err := ioutil.WriteFile(path, secret, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write stable privacy secret: %v", err)
+		}
+	}
+	d.ipv6Secret = secret
+	return secret, nil
+}
+
+// randomIPv6 picks a random address in the pool.
+func randomIPv6(pool *net.IPNet) (net.IP, error) {
+	ip := make(net.IP, net.IPv6len)
+	if _, err := rand.Read(ip); err != nil {
+		return nil, fmt.Errorf("failed to generate a random IPv6 address: %v", err)
+	}
+	prefix := pool.IP.To16()
+	for i := range ip {
+		ip[i] = prefix[i]&pool.Mask[i] | ip[i]&^pool.Mask[i]
+	}
+	return ip, nil
+}
+
+// reservedIID tells whether the interface identifier of the address is reserved: the subnet-router anycast identifier,
+// or one of the reserved subnet anycast identifiers (RFC 5453).
+func reservedIID(ip net.IP) bool {
+	iid := ip[8:]
+	zero := true
+	for _, b := range iid {
+		zero = zero && b == 0
+	}
+	if zero {
+		return true
+	}
+	anycast := []byte{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	return string(iid[:7]) == string(anycast) && iid[7] >= 0x80
+}
+
+// ipv6InUse tells whether the address is used by another endpoint of the network, or is the gateway of a subnet.
+func (n *bridgeNetwork) ipv6InUse(config *networkConfiguration, ep *bridgeEndpoint, ip net.IP) bool {
+	for _, sn := range config.SubnetsIPv6 {
+		if sn.Gateway != nil && sn.Gateway.Equal(ip) {
+			return true
+		}
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	for _, other := range n.endpoints {
+		if other != ep && other.addrv6 != nil && other.addrv6.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
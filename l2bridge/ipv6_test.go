@@ -0,0 +1,214 @@
+package l2bridge
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestEUI64IPv6(t *testing.T) {
+	tests := []struct {
+		pool, mac, want string
+	}{
+		{pool: "2001:db8::/64", mac: "02:42:ac:11:00:02", want: "2001:db8::42:acff:fe11:2"},
+		{pool: "2001:db8:1:2::/64", mac: "00:11:22:33:44:55", want: "2001:db8:1:2:211:22ff:fe33:4455"},
+		{pool: "fd00::/48", mac: "ff:ff:ff:ff:ff:ff", want: "fd00::fdff:ffff:feff:ffff"},
+	}
+
+	for _, tt := range tests {
+		mac, err := net.ParseMAC(tt.mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := eui64IPv6(mustParseCIDR(t, tt.pool), mac); !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("eui64IPv6(%s, %s) = %s, expected %s", tt.pool, tt.mac, got, tt.want)
+		}
+	}
+}
+
+func TestMACIPv6(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	want := net.ParseIP("2001:db8::242:ac11:2")
+	if got := macIPv6(mustParseCIDR(t, "2001:db8::/80"), mac); !got.Equal(want) {
+		t.Errorf("macIPv6() = %s, expected %s", got, want)
+	}
+}
+
+func TestStablePrivacyIPv6(t *testing.T) {
+	pool := mustParseCIDR(t, "2001:db8::/64")
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ip := stablePrivacyIPv6(pool, secret, "network", "endpoint", 0)
+
+	if !pool.Contains(ip) {
+		t.Fatalf("Address %s is not in prefix %s", ip, pool)
+	}
+	if again := stablePrivacyIPv6(pool, secret, "network", "endpoint", 0); !again.Equal(ip) {
+		t.Errorf("Address is not stable: got %s, then %s", ip, again)
+	}
+
+	variants := []struct {
+		name   string
+		pool   *net.IPNet
+		secret []byte
+		nid    string
+		eid    string
+		count  int
+	}{
+		{name: "prefix", pool: mustParseCIDR(t, "2001:db8:1::/64"), secret: secret, nid: "network", eid: "endpoint"},
+		{name: "secret", pool: pool, secret: []byte("another secret"), nid: "network", eid: "endpoint"},
+		{name: "network", pool: pool, secret: secret, nid: "other", eid: "endpoint"},
+		{name: "endpoint", pool: pool, secret: secret, nid: "network", eid: "other"},
+		{name: "attempt", pool: pool, secret: secret, nid: "network", eid: "endpoint", count: 1},
+	}
+	for _, v := range variants {
+		other := stablePrivacyIPv6(v.pool, v.secret, v.nid, v.eid, v.count)
+		if bytes.Equal(other[8:], ip[8:]) {
+			t.Errorf("Changing the %s did not change the interface identifier %s", v.name, ip)
+		}
+	}
+}
+
+func TestRandomIPv6(t *testing.T) {
+	for _, cidr := range []string{"2001:db8::/64", "2001:db8::/120", "2001:db8::1/127"} {
+		pool := mustParseCIDR(t, cidr)
+		pool.IP = pool.IP.Mask(pool.Mask)
+		for i := 0; i < 16; i++ {
+			ip, err := randomIPv6(pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pool.Contains(ip) {
+				t.Fatalf("randomIPv6(%s) = %s, which is out of the pool", cidr, ip)
+			}
+		}
+	}
+}
+
+func TestReservedIID(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "2001:db8::", want: true},
+		{ip: "2001:db8::fdff:ffff:ffff:ff80", want: true},
+		{ip: "2001:db8::fdff:ffff:ffff:ffff", want: true},
+		{ip: "2001:db8::fdff:ffff:ffff:ff7f", want: false},
+		{ip: "2001:db8::1", want: false},
+		{ip: "2001:db8::42:acff:fe11:2", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := reservedIID(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("reservedIID(%s) = %t, expected %t", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateIPv6(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	tests := []struct {
+		pool, mode string
+		want       string // empty if any address of the pool will do
+		wantErr    bool
+	}{
+		{pool: "2001:db8::/64", want: "2001:db8::42:acff:fe11:2"},
+		{pool: "2001:db8::/64", mode: AddrGenModeEUI64, want: "2001:db8::42:acff:fe11:2"},
+		{pool: "2001:db8::/80", want: "2001:db8::242:ac11:2"},
+		{pool: "2001:db8::/112"},
+		{pool: "2001:db8::/64", mode: AddrGenModeRandom},
+		{pool: "2001:db8::/120", mode: AddrGenModeRandom},
+		{pool: "2001:db8::/64", mode: AddrGenModeStablePrivacy},
+		{pool: "2001:db8::/80", mode: AddrGenModeStablePrivacy, wantErr: true},
+		{pool: "2001:db8::1/128", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		pool := mustParseCIDR(t, tt.pool)
+		pool.IP = pool.IP.Mask(pool.Mask)
+		d := NewBridgeDriver(&Configuration{})
+		config := &networkConfiguration{
+			ID:              "network",
+			EnableIPv6:      true,
+			SubnetsIPv6:     []*subnet{{Pool: pool}},
+			IPv6AddrGenMode: tt.mode,
+		}
+		n := &bridgeNetwork{id: config.ID, config: config, driver: d, endpoints: make(map[string]*bridgeEndpoint)}
+		ep := &bridgeEndpoint{id: "endpoint", macAddress: mac}
+
+		addr, err := n.generateIPv6(config, ep)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("generateIPv6(%s, %q) = %s, expected an error", tt.pool, tt.mode, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("generateIPv6(%s, %q) failed: %v", tt.pool, tt.mode, err)
+			continue
+		}
+		if !pool.Contains(addr.IP) || addr.Mask.String() != pool.Mask.String() {
+			t.Errorf("generateIPv6(%s, %q) = %s, which is out of the pool", tt.pool, tt.mode, addr)
+		}
+		if tt.want != "" && !addr.IP.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("generateIPv6(%s, %q) = %s, expected %s", tt.pool, tt.mode, addr.IP, tt.want)
+		}
+	}
+}
+
+func TestGenerateIPv6InUse(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	pool := mustParseCIDR(t, "2001:db8::/64")
+	d := NewBridgeDriver(&Configuration{})
+	config := &networkConfiguration{ID: "network", EnableIPv6: true, SubnetsIPv6: []*subnet{{Pool: pool}}}
+	n := &bridgeNetwork{id: config.ID, config: config, driver: d, endpoints: make(map[string]*bridgeEndpoint)}
+
+	taken := &bridgeEndpoint{id: "taken", addrv6: &net.IPNet{IP: net.ParseIP("2001:db8::42:acff:fe11:2"), Mask: pool.Mask}}
+	n.endpoints[taken.id] = taken
+
+	if addr, err := n.generateIPv6(config, &bridgeEndpoint{id: "endpoint", macAddress: mac}); err == nil {
+		t.Errorf("generateIPv6() = %s, which is in use", addr)
+	}
+
+	config.IPv6AddrGenMode = AddrGenModeStablePrivacy
+	addr, err := n.generateIPv6(config, &bridgeEndpoint{id: "endpoint", macAddress: mac})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.IP.Equal(taken.addrv6.IP) {
+		t.Errorf("generateIPv6() = %s, which is in use", addr)
+	}
+}
+
+func TestStablePrivacySecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "l2bridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secret, err := NewBridgeDriver(&Configuration{StateDir: dir}).stablePrivacySecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != ipv6SecretLen {
+		t.Fatalf("Expected a secret of %d bytes, got %d", ipv6SecretLen, len(secret))
+	}
+
+	again, err := NewBridgeDriver(&Configuration{StateDir: dir}).stablePrivacySecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, again) {
+		t.Error("The secret was not persisted across instances of the driver")
+	}
+
+	other, err := NewBridgeDriver(&Configuration{}).stablePrivacySecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(secret, other) {
+		t.Error("A driver without a state directory used the persisted secret")
+	}
+}
@@ -0,0 +1,107 @@
+package l2bridge
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// peerPair is a pair of bridges, traffic from the first of which may be forwarded to the second.
+type peerPair struct {
+	from, to string
+}
+
+// resolvePeer returns the network named by an entry of a peer list, which is either a network ID, a unique prefix of
+// one, or a bridge name. It returns nil if no network, or more than one, matches.
+func (d *bridgeDriver) resolvePeer(peer string) *bridgeNetwork {
+	var match *bridgeNetwork
+	for _, nw := range d.getNetworks() {
+		nw.Lock()
+		id, bridgeName := nw.id, nw.config.BridgeName
+		nw.Unlock()
+
+		if id == peer || bridgeName == peer {
+			return nw
+		}
+		if strings.HasPrefix(id, peer) {
+			if match != nil {
+				return nil
+			}
+			match = nw
+		}
+	}
+	return match
+}
+
+// peerPairs returns the pairs of bridges between which the peer lists of the networks allow forwarding. Peers which
+// do not name an existing network are skipped, until such a network is created.
+func (d *bridgeDriver) peerPairs() map[peerPair]bool {
+	pairs := make(map[peerPair]bool)
+	for _, nw := range d.getNetworks() {
+		nw.Lock()
+		config := nw.config
+		nw.Unlock()
+
+		for _, peer := range config.Peers {
+			pn := d.resolvePeer(peer)
+			if pn == nil {
+				logrus.Debugf("Peer %s of network %.7s does not match a single network", peer, config.ID)
+				continue
+			}
+			pn.Lock()
+			from := pn.config.BridgeName
+			pn.Unlock()
+
+			if from != config.BridgeName {
+				pairs[peerPair{from: from, to: config.BridgeName}] = true
+			}
+		}
+	}
+	return pairs
+}
+
+// syncPeerForwarding programs the forwarding rules between peer networks, and removes those which are no longer
+// allowed because a network was deleted. It is called whenever networks are created, restored or deleted.
+func (d *bridgeDriver) syncPeerForwarding() error {
+	d.Lock()
+	fw := d.firewall
+	d.Unlock()
+
+	if fw == nil {
+		return nil
+	}
+
+	want := d.peerPairs()
+
+	// Only the rules which are in place are recorded, so that those which failed to be programmed or removed are
+	// retried by the next sync.
+	programmed := make(map[peerPair]bool)
+	d.Lock()
+	for pair := range d.peerForwarding {
+		programmed[pair] = true
+	}
+	d.Unlock()
+	defer func() {
+		d.Lock()
+		d.peerForwarding = programmed
+		d.Unlock()
+	}()
+
+	for pair := range programmed {
+		if want[pair] {
+			continue
+		}
+		if err := fw.setPeerForwarding(pair.from, pair.to, false); err != nil {
+			logrus.WithError(err).Warnf("Failed to remove forwarding from %s to %s: %v", pair.from, pair.to, err)
+			continue
+		}
+		delete(programmed, pair)
+	}
+	for pair := range want {
+		if err := fw.setPeerForwarding(pair.from, pair.to, true); err != nil {
+			return err
+		}
+		programmed[pair] = true
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package l2bridge
+
+import (
+	"github.com/docker/libnetwork/osl"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// reconcile brings the kernel state in line with the networks and endpoints restored from the store. Bridges which
+// are missing, e.g. after a host reboot, are re-created and the setup steps are re-applied to every bridge. Failures
+// are logged, but the network is kept so that Docker can still delete it.
+func (d *bridgeDriver) reconcile() {
+	defer osl.InitOSContext()()
+
+	for _, n := range d.getNetworks() {
+		if err := d.reconcileNetwork(n); err != nil {
+			logrus.WithError(err).Warnf("Failed to reconcile bridge network %.7s: %v", n.id, err)
+		}
+	}
+}
+
+func (d *bridgeDriver) reconcileNetwork(n *bridgeNetwork) error {
+	n.Lock()
+	config := n.config
+	n.Unlock()
+
+	bridgeIface, err := newInterface(d.nlh, config)
+	if err != nil {
+		return err
+	}
+	if !bridgeIface.exists() {
+		logrus.Infof("Bridge %s for network %.7s is missing and will be re-created", config.BridgeName, n.id)
+	}
+
+	// Rules are re-programmed by the setup steps, which will register fresh clean functions.
+	n.Lock()
+	n.bridge = bridgeIface
+	n.iptCleanFuncs = nil
+	n.Unlock()
+
+	if err := n.setupBridge(); err != nil {
+		return err
+	}
+	if err := d.reconcileEndpoints(n); err != nil {
+		return err
+	}
+	if config.Antispoof {
+		n.reconcileAntispoof()
+	}
+	if config.HostGateway {
+		n.reconcileExternalConnectivity(true)
+	}
+	if config.DHCP {
+		if err := n.startDHCPServer(); err != nil {
+			return err
+		}
+	}
+	if config.VlanFiltering {
+		return d.syncTrunks(config.BridgeName)
+	}
+	return nil
+}
+
+// reconcileEndpoints re-attaches the host side interfaces of known endpoints to the bridge, and deletes veths which
+// are enslaved to the bridge but belong to no endpoint, nor are an uplink. All networks sharing the bridge are taken
+// into account.
+func (d *bridgeDriver) reconcileEndpoints(n *bridgeNetwork) error {
+	n.Lock()
+	config := n.config
+	bridgeIndex := n.bridge.Link.Attrs().Index
+	n.Unlock()
+
+	type knownEndpoint struct {
+		ep     *bridgeEndpoint
+		config *networkConfiguration
+	}
+	known := make(map[string]knownEndpoint)
+	uplinks := make(map[string]bool)
+	complete := true
+	for _, nw := range d.getBridgeNetworks(config.BridgeName) {
+		nw.Lock()
+		for _, name := range nw.config.Uplinks {
+			uplinks[name] = true
+		}
+		for _, ep := range nw.endpoints {
+			// Endpoints recorded without a host interface name make it impossible to tell orphans apart.
+			if ep.hostName == "" {
+				complete = false
+				continue
+			}
+			known[ep.hostName] = knownEndpoint{ep: ep, config: nw.config}
+		}
+		nw.Unlock()
+	}
+
+	links, err := d.nlh.LinkList()
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if _, ok := link.(*netlink.Veth); !ok {
+			continue
+		}
+
+		name := link.Attrs().Name
+		k, isKnown := known[name]
+		switch {
+		case isKnown && link.Attrs().MasterIndex != bridgeIndex:
+			logrus.Infof("Re-attaching interface %s to bridge %s", name, config.BridgeName)
+			if err := d.attachEndpoint(k.config, k.ep, link); err != nil {
+				logrus.WithError(err).Warnf("Failed to re-attach interface %s to bridge %s: %v", name, config.BridgeName, err)
+			}
+		case !isKnown && !uplinks[name] && complete && link.Attrs().MasterIndex == bridgeIndex:
+			logrus.Infof("Deleting orphaned interface %s on bridge %s", name, config.BridgeName)
+			if err := d.nlh.LinkDel(link); err != nil {
+				logrus.WithError(err).Warnf("Failed to delete orphaned interface %s: %v", name, err)
+			}
+		}
+	}
+
+	if !complete {
+		logrus.Warnf("Skipped orphaned interface cleanup on bridge %s: some endpoints have no recorded host interface", config.BridgeName)
+	}
+	return nil
+}
+
+// reconcileAntispoof re-installs the antispoof rules of the endpoints, registering their clean functions again.
+func (n *bridgeNetwork) reconcileAntispoof() {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, ep := range n.endpoints {
+		ep.cleanFuncs = nil
+		if err := ep.setupAntispoof(); err != nil {
+			logrus.WithError(err).Warnf("Failed to restore antispoof rules for endpoint %.7s: %v", ep.id, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package l2bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestReconcileKeepsUplinks(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer setupTestOSContext(t)()
+	}
+
+	dir, err := ioutil.TempDir("", "l2bridge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addTestVeth(t, "uplink0")
+	d := newTestDriver(t, &Configuration{StateDir: dir})
+	config := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", Uplinks: []string{"uplink0"}}
+	if err := createTestNetwork(t, d, config, "192.168.0.0/24"); err != nil {
+		t.Fatalf("Failed to create the network: %v", err)
+	}
+
+	// A veth on the bridge which belongs to no endpoint is left over by an endpoint whose deletion was missed.
+	orphan := addTestVeth(t, "orphan0")
+	bridge, err := netlink.LinkByName(config.BridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetMaster(orphan, bridge.(*netlink.Bridge)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new driver over the same state directory reconciles the restored network, as on a restart of the plugin.
+	newTestDriver(t, &Configuration{StateDir: dir})
+
+	if _, err := netlink.LinkByName("orphan0"); err == nil {
+		t.Error("The orphaned interface was not deleted")
+	}
+	if _, err := netlink.LinkByName("uplink0"); err != nil {
+		t.Fatalf("The uplink was deleted: %v", err)
+	}
+	if master := masterName(t, "uplink0"); master != config.BridgeName {
+		t.Errorf("Uplink is attached to %q, expected %q", master, config.BridgeName)
+	}
+}
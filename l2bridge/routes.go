@@ -0,0 +1,72 @@
+package l2bridge
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// parseRoutes parses a semicolon-separated list of static routes, each either "<destination> via <next hop>" or
+// "<destination> connected", e.g. "10.0.0.0/8 via 192.168.0.254;172.16.0.0/12 connected". The result is never nil,
+// so that an empty list can be told apart from no list at all.
+func parseRoutes(s string) ([]*StaticRoute, error) {
+	routes := []*StaticRoute{}
+	for _, spec := range strings.Split(s, ";") {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+
+		_, dst, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %v", spec, err)
+		}
+
+		route := &StaticRoute{Destination: dst}
+		switch {
+		case len(fields) == 3 && fields[1] == "via":
+			route.RouteType = types.NEXTHOP
+			if route.NextHop = net.ParseIP(fields[2]); route.NextHop == nil {
+				return nil, fmt.Errorf("invalid route %q: %s is not a valid IP address", spec, fields[2])
+			}
+			if (route.NextHop.To4() == nil) != (dst.IP.To4() == nil) {
+				return nil, fmt.Errorf("invalid route %q: destination and next hop are of different address families", spec)
+			}
+		case len(fields) == 2 && fields[1] == "connected":
+			route.RouteType = types.CONNECTED
+		default:
+			return nil, fmt.Errorf("invalid route %q: expected \"<destination> via <next hop>\" or \"<destination> connected\"", spec)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// formatRoutes is the inverse of parseRoutes.
+func formatRoutes(routes []*StaticRoute) string {
+	specs := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if route.RouteType == types.CONNECTED {
+			specs = append(specs, fmt.Sprintf("%s connected", route.Destination))
+		} else {
+			specs = append(specs, fmt.Sprintf("%s via %s", route.Destination, route.NextHop))
+		}
+	}
+	return strings.Join(specs, ";")
+}
+
+// validateRoutes checks that the next hops of the routes are on the network, so that containers can reach them.
+func (c *networkConfiguration) validateRoutes(routes []*StaticRoute) error {
+	for _, route := range routes {
+		if route.RouteType != types.NEXTHOP {
+			continue
+		}
+
+		if c.subnetOf(route.NextHop) == nil {
+			return types.BadRequestErrorf("next hop %s of route to %s is not on the network", route.NextHop, route.Destination)
+		}
+	}
+	return nil
+}
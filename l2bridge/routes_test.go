@@ -0,0 +1,111 @@
+package l2bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: " ; ", want: ""},
+		{in: "10.0.0.0/8 via 192.168.0.254", want: "10.0.0.0/8 via 192.168.0.254"},
+		{in: "172.16.0.0/12 connected", want: "172.16.0.0/12 connected"},
+		{
+			in:   "10.0.0.0/8 via 192.168.0.254; 172.16.0.0/12 connected;",
+			want: "10.0.0.0/8 via 192.168.0.254;172.16.0.0/12 connected",
+		},
+		{in: "10.1.2.3/8 via 192.168.0.254", want: "10.0.0.0/8 via 192.168.0.254"},
+		{in: "2001:db8::/32 via fe80::1", want: "2001:db8::/32 via fe80::1"},
+		{in: "10.0.0.0 via 192.168.0.254", wantErr: true},
+		{in: "10.0.0.0/8 via nowhere", wantErr: true},
+		{in: "10.0.0.0/8 via", wantErr: true},
+		{in: "10.0.0.0/8 through 192.168.0.254", wantErr: true},
+		{in: "10.0.0.0/8 connected 192.168.0.254", wantErr: true},
+		{in: "10.0.0.0/8 via fe80::1", wantErr: true},
+		{in: "2001:db8::/32 via 192.168.0.254", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		routes, err := parseRoutes(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRoutes(%q) succeeded, expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRoutes(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if routes == nil {
+			t.Errorf("parseRoutes(%q) returned nil, expected an empty list", tt.in)
+		}
+		if got := formatRoutes(routes); got != tt.want {
+			t.Errorf("formatRoutes(parseRoutes(%q)) = %q, expected %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRoutesTypes(t *testing.T) {
+	routes, err := parseRoutes("10.0.0.0/8 via 192.168.0.254;172.16.0.0/12 connected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d", len(routes))
+	}
+	if routes[0].RouteType != types.NEXTHOP || routes[0].NextHop.String() != "192.168.0.254" {
+		t.Errorf("Expected a route via 192.168.0.254, got type %d via %v", routes[0].RouteType, routes[0].NextHop)
+	}
+	if routes[1].RouteType != types.CONNECTED || routes[1].NextHop != nil {
+		t.Errorf("Expected a connected route, got type %d via %v", routes[1].RouteType, routes[1].NextHop)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	config := &networkConfiguration{
+		SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}},
+		SubnetsIPv6: []*subnet{{Pool: mustParseCIDR(t, "2001:db8::/64")}},
+	}
+
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: "10.0.0.0/8 via 192.168.0.254"},
+		{in: "10.0.0.0/8 connected"},
+		{in: "2001:db8:1::/48 via 2001:db8::1"},
+		{in: "10.0.0.0/8 via 192.168.1.254", wantErr: true},
+		{in: "2001:db8:1::/48 via 2001:db8:1::1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		routes, err := parseRoutes(tt.in)
+		if err != nil {
+			t.Fatalf("parseRoutes(%q) failed: %v", tt.in, err)
+		}
+		err = config.validateRoutes(routes)
+		if tt.wantErr && err == nil {
+			t.Errorf("validateRoutes(%q) succeeded, expected an error", tt.in)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("validateRoutes(%q) failed: %v", tt.in, err)
+		}
+	}
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	ip, pool, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.IP = ip
+	return pool
+}
@@ -0,0 +1,114 @@
+package l2bridge
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// antispoofChainPrefix is prepended to the host interface name to form the ebtables chain of an endpoint.
+const antispoofChainPrefix = "L2BR-"
+
+// antispoofParentChains are the chains which send frames received on an endpoint port to its antispoof chain.
+var antispoofParentChains = []string{"FORWARD", "INPUT"}
+
+func antispoofChain(hostIfName string) string {
+	return antispoofChainPrefix + hostIfName
+}
+
+func ebtables(args ...string) error {
+	if out, err := exec.Command("ebtables", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ebtables %s failed: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// antispoofRules returns the rules dropping frames which do not come from the MAC and IP addresses assigned to the
+// endpoint. Link-local and unspecified IPv6 sources are allowed, as they are needed for neighbor discovery, and so are
+// DHCP requests from the unspecified IPv4 address.
+func antispoofRules(ep *bridgeEndpoint) [][]string {
+	mac := ep.macAddress.String()
+	rules := [][]string{
+		{"-s", "!", mac, "-j", "DROP"},
+		{"-p", "ARP", "--arp-mac-src", "!", mac, "-j", "DROP"},
+		{"-p", "IPv4", "--ip-src", "0.0.0.0", "--ip-proto", "udp", "--ip-dport", "67", "-j", "RETURN"},
+	}
+
+	if ep.addr != nil {
+		ip := ep.addr.IP.String()
+		rules = append(rules,
+			[]string{"-p", "ARP", "--arp-ip-src", "!", ip, "-j", "DROP"},
+			[]string{"-p", "IPv4", "--ip-src", "!", ip, "-j", "DROP"},
+		)
+	} else {
+		rules = append(rules,
+			[]string{"-p", "ARP", "-j", "DROP"},
+			[]string{"-p", "IPv4", "-j", "DROP"},
+		)
+	}
+
+	rules = append(rules,
+		[]string{"-p", "IPv6", "--ip6-src", "fe80::/10", "-j", "RETURN"},
+		[]string{"-p", "IPv6", "--ip6-src", "::/128", "-j", "RETURN"},
+	)
+	if ep.addrv6 != nil {
+		rules = append(rules, []string{"-p", "IPv6", "--ip6-src", "!", ep.addrv6.IP.String(), "-j", "DROP"})
+	} else {
+		rules = append(rules, []string{"-p", "IPv6", "-j", "DROP"})
+	}
+	return rules
+}
+
+// setupAntispoof installs ebtables rules on the host side interface of the endpoint, allowing only frames sent from
+// its assigned addresses. Existing rules for the interface are replaced, so that it can be re-applied on restore.
+func (ep *bridgeEndpoint) setupAntispoof() error {
+	if ep.hostName == "" {
+		return fmt.Errorf("endpoint %.7s has no host interface to install antispoof rules on", ep.id)
+	}
+	hostIfName, chain := ep.hostName, antispoofChain(ep.hostName)
+
+	if err := removeAntispoof(hostIfName); err != nil {
+		logrus.Debugf("No previous antispoof rules removed for %s: %v", hostIfName, err)
+	}
+
+	if err := ebtables("-t", "filter", "-N", chain, "-P", "RETURN"); err != nil {
+		return fmt.Errorf("unable to create antispoof chain: %v", err)
+	}
+	ep.registerCleanFunc(func() error {
+		return removeAntispoof(hostIfName)
+	})
+
+	for _, rule := range antispoofRules(ep) {
+		if err := ebtables(append([]string{"-t", "filter", "-A", chain}, rule...)...); err != nil {
+			return fmt.Errorf("unable to setup antispoof rule: %v", err)
+		}
+	}
+	for _, parent := range antispoofParentChains {
+		if err := ebtables("-t", "filter", "-A", parent, "-i", hostIfName, "-j", chain); err != nil {
+			return fmt.Errorf("unable to setup antispoof jump rule: %v", err)
+		}
+	}
+	return nil
+}
+
+// removeAntispoof removes the antispoof chain of the host side interface and the rules jumping to it.
+func removeAntispoof(hostIfName string) error {
+	chain := antispoofChain(hostIfName)
+
+	var errs []string
+	for _, parent := range antispoofParentChains {
+		if err := ebtables("-t", "filter", "-D", parent, "-i", hostIfName, "-j", chain); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := ebtables("-t", "filter", "-X", chain); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to cleanup antispoof rules: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
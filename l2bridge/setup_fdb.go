@@ -0,0 +1,123 @@
+package l2bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// setupStaticFdb pins the MAC address of the endpoint to its port on the bridge, and turns off learning and unknown
+// unicast flooding on the port. Frames then only reach the port owning the destination MAC, and the endpoint cannot
+// claim other addresses by sending frames from them.
+//
+// The bridge also answers ARP requests and IPv6 neighbour solicitations for the addresses of the endpoint from the
+// neighbour entries added for them, instead of flooding the requests. Neighbour solicitations sent by other endpoints
+// are the exception: the kernel only answers those arriving through ports which do not suppress neighbour discovery,
+// such as uplinks, and floods the others as usual.
+func setupStaticFdb(nlh *netlink.Handle, config *networkConfiguration, ep *bridgeEndpoint, host netlink.Link) error {
+	if ep.macAddress == nil {
+		return fmt.Errorf("endpoint %.7s has no MAC address to add to the forwarding database", ep.id)
+	}
+
+	// NUD_NOARP makes the entry static. NUD_PERMANENT would mark the address as local to the bridge instead.
+	fdb := &netlink.Neigh{
+		LinkIndex:    host.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		State:        netlink.NUD_NOARP,
+		Flags:        netlink.NTF_MASTER,
+		HardwareAddr: ep.macAddress,
+	}
+	if err := nlh.NeighSet(fdb); err != nil {
+		return fmt.Errorf("failed to add forwarding database entry for %s on %s: %v", ep.macAddress, host.Attrs().Name, err)
+	}
+
+	if err := nlh.LinkSetLearning(host, false); err != nil {
+		return fmt.Errorf("failed to disable learning on %s: %v", host.Attrs().Name, err)
+	}
+	if err := nlh.LinkSetFlood(host, false); err != nil {
+		return fmt.Errorf("failed to disable flooding on %s: %v", host.Attrs().Name, err)
+	}
+
+	bridge, err := nlh.LinkByName(config.BridgeName)
+	if err != nil {
+		return fmt.Errorf("could not find bridge %s: %v", config.BridgeName, err)
+	}
+	for _, addr := range ep.neighAddrs() {
+		if err := nlh.NeighSet(bridgeNeigh(bridge, addr, ep.macAddress)); err != nil {
+			return fmt.Errorf("failed to add neighbour entry for %s on %s: %v", addr, config.BridgeName, err)
+		}
+	}
+
+	// Answering from the neighbour entries requires neigh_suppress on the port owning the address. Proxy ARP lets
+	// the bridge answer the ARP requests the endpoint sends in turn, which neigh_suppress alone leaves out.
+	path := filepath.Join("/sys/class/net", host.Attrs().Name, "brport/neigh_suppress")
+	if err := setSysBoolParam(path, true); err != nil {
+		return fmt.Errorf("failed to enable neighbour suppression on %s: %v", host.Attrs().Name, err)
+	}
+	if err := nlh.LinkSetBrProxyArp(host, true); err != nil {
+		return fmt.Errorf("failed to enable proxy ARP on %s: %v", host.Attrs().Name, err)
+	}
+	return nil
+}
+
+// setupNeighboursIPv6 enables IPv6 on the bridge so that it can hold the IPv6 neighbour entries of the endpoints,
+// while preventing it from obtaining an address, as setupDisableIPv6 does otherwise.
+func setupNeighboursIPv6(config *networkConfiguration, i *bridgeInterface) error {
+	dir := fmt.Sprintf("/proc/sys/net/ipv6/conf/%s", config.BridgeName)
+	// An addr_gen_mode of 1 generates no link-local address.
+	if err := ioutil.WriteFile(filepath.Join(dir, "addr_gen_mode"), []byte{'1', '\n'}, 0644); err != nil {
+		return fmt.Errorf("failed to disable ipv6 address generation on bridge %s: %v", config.BridgeName, err)
+	}
+	if err := setSysBoolParam(filepath.Join(dir, "accept_ra"), false); err != nil {
+		return fmt.Errorf("failed to disable ipv6 router advertisements on bridge %s: %v", config.BridgeName, err)
+	}
+	if err := setSysBoolParam(filepath.Join(dir, "disable_ipv6"), false); err != nil {
+		return fmt.Errorf("failed to enable ipv6 on bridge %s: %v", config.BridgeName, err)
+	}
+	return nil
+}
+
+// releaseStaticFdb removes the neighbour entries added to the bridge for the addresses of the endpoint. The
+// forwarding database entry goes away with the port. It is a best effort, and failures are only logged.
+func releaseStaticFdb(nlh *netlink.Handle, config *networkConfiguration, ep *bridgeEndpoint) {
+	bridge, err := nlh.LinkByName(config.BridgeName)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to find bridge %s on release of endpoint %.7s: %v", config.BridgeName, ep.id, err)
+		return
+	}
+	for _, addr := range ep.neighAddrs() {
+		if err := nlh.NeighDel(bridgeNeigh(bridge, addr, ep.macAddress)); err != nil && err != syscall.ENOENT {
+			logrus.WithError(err).Warnf("Failed to remove neighbour entry for %s on %s: %v", addr, config.BridgeName, err)
+		}
+	}
+}
+
+// neighAddrs returns the addresses of the endpoint answered by the bridge.
+func (ep *bridgeEndpoint) neighAddrs() []net.IP {
+	var addrs []net.IP
+	for _, addr := range []*net.IPNet{ep.addr, ep.addrv6} {
+		if addr != nil {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+	return addrs
+}
+
+func bridgeNeigh(bridge netlink.Link, ip net.IP, mac net.HardwareAddr) *netlink.Neigh {
+	family := netlink.FAMILY_V4
+	if ip.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	return &netlink.Neigh{
+		LinkIndex:    bridge.Attrs().Index,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		IP:           ip,
+		HardwareAddr: mac,
+	}
+}
@@ -0,0 +1,75 @@
+package l2bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestStaticFdbNeighbours(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer setupTestOSContext(t)()
+	}
+
+	d := newTestDriver(t, &Configuration{})
+	config := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", StaticFdb: true, EnableIPv6: true}
+	if err := createTestNetwork(t, d, config, "192.168.0.0/24"); err != nil {
+		t.Fatalf("Failed to create the network: %v", err)
+	}
+
+	mac, _ := net.ParseMAC("02:42:c0:a8:00:02")
+	ep := &bridgeEndpoint{
+		id:         "endpoint0",
+		macAddress: mac,
+		addr:       mustParseCIDR(t, "192.168.0.2/24"),
+		addrv6:     mustParseCIDR(t, "2001:db8::2/64"),
+	}
+	host := addTestVeth(t, "veth0")
+	if err := d.attachEndpoint(config, ep, host); err != nil {
+		t.Fatalf("Failed to attach the endpoint: %v", err)
+	}
+
+	if value, err := ioutil.ReadFile("/sys/class/net/veth0/brport/neigh_suppress"); err != nil || string(value) != "1\n" {
+		t.Errorf("Neighbour suppression is not enabled on the port: %q, %v", value, err)
+	}
+
+	bridge, err := netlink.LinkByName(config.BridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	neighs := func() map[string]string {
+		found := make(map[string]string)
+		for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			list, err := netlink.NeighList(bridge.Attrs().Index, family)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, neigh := range list {
+				if neigh.State&netlink.NUD_PERMANENT != 0 {
+					found[neigh.IP.String()] = neigh.HardwareAddr.String()
+				}
+			}
+		}
+		return found
+	}
+
+	found := neighs()
+	for _, ip := range []string{"192.168.0.2", "2001:db8::2"} {
+		if found[ip] != mac.String() {
+			t.Errorf("Neighbour entry of %s on the bridge = %q, expected %q", ip, found[ip], mac)
+		}
+	}
+
+	// IPv6 is enabled on the bridge to hold the entries, without giving it an address.
+	if addrs, err := netlink.AddrList(bridge, netlink.FAMILY_V6); err != nil || len(addrs) != 0 {
+		t.Errorf("Bridge has IPv6 addresses %v, %v", addrs, err)
+	}
+
+	releaseStaticFdb(d.nlh, config, ep)
+	if found := neighs(); len(found) != 0 {
+		t.Errorf("Neighbour entries %v were left on the bridge", found)
+	}
+}
@@ -1,19 +1,62 @@
 package l2bridge
 
-import "github.com/docker/libnetwork/iptables"
+import "github.com/sirupsen/logrus"
 
 func (n *bridgeNetwork) setupFirewalld(config *networkConfiguration, i *bridgeInterface) error {
 	d := n.driver
 	d.Lock()
 	driverConfig := d.config
+	fw := d.firewall
 	d.Unlock()
 
 	// Sanity check.
-	if !driverConfig.EnableIPTables {
+	if !driverConfig.EnableIPTables || fw == nil {
 		return IPTableCfgError(config.BridgeName)
 	}
 
-	iptables.OnReloaded(func() { n.setupIPTables(config, i) })
+	// Callbacks cannot be unregistered, so the one of the network is registered once, however many times its setup
+	// steps are applied.
+	n.Lock()
+	registered := n.reloadRegistered
+	n.reloadRegistered = true
+	n.Unlock()
+	if !registered {
+		fw.onReloaded(n.reloadIPTables)
+	}
 
 	return nil
 }
+
+// reloadIPTables re-programs the rules of setupIPTables and setupMasquerade, and those of the endpoints with external
+// connectivity, after a firewall reload flushed them, unless the network has been deleted since. The clean functions
+// registered by the setup steps still apply, so none are registered.
+func (n *bridgeNetwork) reloadIPTables() {
+	d := n.driver
+	d.Lock()
+	current := d.networks[n.id] == n
+	fw := d.firewall
+	d.Unlock()
+
+	if !current || fw == nil {
+		return
+	}
+
+	n.Lock()
+	config := n.config
+	n.Unlock()
+
+	if err := fw.setLocalForwarding(config.BridgeName, true); err != nil {
+		logrus.WithError(err).Warnf("Failed to restore iptables rules of bridge %s on firewall reload: %v", config.BridgeName, err)
+	}
+	if err := d.syncPeerForwarding(); err != nil {
+		logrus.WithError(err).Warnf("Failed to restore peer forwarding rules on firewall reload: %v", err)
+	}
+	if config.Masquerade {
+		for _, sn := range append(append([]*subnet{}, config.SubnetsIPv4...), config.SubnetsIPv6...) {
+			if err := fw.setMasquerade(config.BridgeName, sn.Pool, true); err != nil {
+				logrus.WithError(err).Warnf("Failed to restore masquerade rule of pool %s on firewall reload: %v", sn.Pool, err)
+			}
+		}
+	}
+	n.reconcileExternalConnectivity(false)
+}
@@ -0,0 +1,153 @@
+package l2bridge
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// testFirewall records the rules programmed through it, and lets tests flush them as a firewall reload does.
+type testFirewall struct {
+	rules     map[string]bool
+	callbacks []func()
+	noIPv6    bool // whether the backend does not program IPv6 rules, as with iptables
+}
+
+func newTestFirewall() *testFirewall {
+	return &testFirewall{rules: make(map[string]bool)}
+}
+
+func (fw *testFirewall) set(rule string, enable bool) error {
+	if enable {
+		fw.rules[rule] = true
+	} else {
+		delete(fw.rules, rule)
+	}
+	return nil
+}
+
+// reload flushes the rules and runs the reload callbacks.
+func (fw *testFirewall) reload() {
+	fw.rules = make(map[string]bool)
+	for _, callback := range fw.callbacks {
+		callback()
+	}
+}
+
+func (fw *testFirewall) dropForwarding() error {
+	return nil
+}
+
+func (fw *testFirewall) setLocalForwarding(bridgeName string, enable bool) error {
+	return fw.set("local "+bridgeName, enable)
+}
+
+func (fw *testFirewall) setPeerForwarding(fromBridge, toBridge string, enable bool) error {
+	return fw.set(fmt.Sprintf("peer %s %s", fromBridge, toBridge), enable)
+}
+
+func (fw *testFirewall) setMasquerade(bridgeName string, pool *net.IPNet, enable bool) error {
+	return fw.set(fmt.Sprintf("masquerade %s %s", bridgeName, pool), enable)
+}
+
+func (fw *testFirewall) setExternalForwarding(bridgeName string, addr net.IP, enable bool) error {
+	return fw.set(fmt.Sprintf("external %s %s", bridgeName, addr), enable)
+}
+
+func (fw *testFirewall) setPortMapping(bridgeName string, binding types.PortBinding, enable bool) error {
+	return fw.set(fmt.Sprintf("port %s %s", bridgeName, binding.String()), enable)
+}
+
+func (fw *testFirewall) onReloaded(callback func()) {
+	fw.callbacks = append(fw.callbacks, callback)
+}
+
+func (fw *testFirewall) supportsIPv6() bool {
+	return !fw.noIPv6
+}
+
+// newTestFirewallNetwork returns a network of a driver programming rules through fw, as if it had been created.
+func newTestFirewallNetwork(fw *testFirewall, config *networkConfiguration) *bridgeNetwork {
+	d := NewBridgeDriver(&Configuration{EnableIPTables: true})
+	d.firewall = fw
+	n := &bridgeNetwork{id: config.ID, config: config, driver: d, endpoints: make(map[string]*bridgeEndpoint)}
+	d.networks[n.id] = n
+	return n
+}
+
+func TestReloadIPTables(t *testing.T) {
+	fw := newTestFirewall()
+	config := &networkConfiguration{
+		ID:          "network0",
+		BridgeName:  "l2b-test0",
+		HostGateway: true,
+		Masquerade:  true,
+		SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}, {Pool: mustParseCIDR(t, "10.0.0.0/24")}},
+		SubnetsIPv6: []*subnet{{Pool: mustParseCIDR(t, "2001:db8::/64")}},
+	}
+	n := newTestFirewallNetwork(fw, config)
+
+	for _, step := range []setupStep{n.setupIPTables, n.setupFirewalld, n.setupFirewalld, n.setupMasquerade} {
+		if err := step(config, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fw.callbacks) != 1 {
+		t.Fatalf("Expected one reload callback, got %d", len(fw.callbacks))
+	}
+
+	want := make(map[string]bool)
+	for rule := range fw.rules {
+		want[rule] = true
+	}
+	for _, rule := range []string{"local l2b-test0", "masquerade l2b-test0 192.168.0.0/24", "masquerade l2b-test0 2001:db8::/64"} {
+		if !want[rule] {
+			t.Fatalf("Rule %q was not programmed by the setup steps, got %v", rule, fw.rules)
+		}
+	}
+
+	fw.reload()
+	for rule := range want {
+		if !fw.rules[rule] {
+			t.Errorf("Rule %q was not restored on reload", rule)
+		}
+	}
+
+	// The rules of a deleted network are not restored.
+	delete(n.driver.networks, n.id)
+	fw.reload()
+	if len(fw.rules) != 0 {
+		t.Errorf("Rules %v of a deleted network were restored on reload", fw.rules)
+	}
+}
+
+func TestReloadIPTablesExternalConnectivity(t *testing.T) {
+	fw := newTestFirewall()
+	config := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", HostGateway: true}
+	n := newTestFirewallNetwork(fw, config)
+
+	ep := &bridgeEndpoint{id: "endpoint0", addr: mustParseCIDR(t, "192.168.0.2/24")}
+	bindings, err := ep.mapPorts([]types.PortBinding{{Proto: types.TCP, Port: 80}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releasePorts(bindings)
+	ep.portMapping, ep.external = bindings, true
+	n.endpoints[ep.id] = ep
+
+	if err := n.setupFirewalld(config, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.driver.setExternalConnectivity(config, ep, true); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.reload()
+	for _, rule := range []string{"external l2b-test0 192.168.0.2", "port l2b-test0 " + bindings[0].String()} {
+		if !fw.rules[rule] {
+			t.Errorf("Rule %q was not restored on reload, got %v", rule, fw.rules)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package l2bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// setupGatewayIPv4 assigns the IPv4 gateway addresses of the network to the bridge, making the host the gateway of the
+// network's endpoints.
+func setupGatewayIPv4(config *networkConfiguration, i *bridgeInterface) error {
+	return setupGatewayAddrs(config, config.SubnetsIPv4, i)
+}
+
+// setupGatewayIPv6 enables IPv6 on the bridge and assigns the IPv6 gateway addresses of the network to it.
+func setupGatewayIPv6(config *networkConfiguration, i *bridgeInterface) error {
+	path := fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/disable_ipv6", config.BridgeName)
+	if err := setSysBoolParam(path, false); err != nil {
+		return fmt.Errorf("failed to enable ipv6 on bridge %s: %v", config.BridgeName, err)
+	}
+	return setupGatewayAddrs(config, config.SubnetsIPv6, i)
+}
+
+func setupGatewayAddrs(config *networkConfiguration, subnets []*subnet, i *bridgeInterface) error {
+	for _, sn := range subnets {
+		if sn.Gateway == nil {
+			continue
+		}
+		addr := &netlink.Addr{IPNet: &net.IPNet{IP: sn.Gateway, Mask: sn.Pool.Mask}}
+		if err := i.nlh.AddrReplace(i.Link, addr); err != nil {
+			return fmt.Errorf("failed to assign gateway address %s to bridge %s: %v", addr.IPNet, config.BridgeName, err)
+		}
+	}
+	return nil
+}
+
+// setupMasquerade masquerades traffic from the pools of the network leaving the host through other interfaces.
+func (n *bridgeNetwork) setupMasquerade(config *networkConfiguration, i *bridgeInterface) error {
+	d := n.driver
+	d.Lock()
+	fw := d.firewall
+	d.Unlock()
+
+	if fw == nil {
+		return IPTableCfgError(config.BridgeName)
+	}
+
+	for _, sn := range append(append([]*subnet{}, config.SubnetsIPv4...), config.SubnetsIPv6...) {
+		pool := sn.Pool
+		if err := fw.setMasquerade(config.BridgeName, pool, true); err != nil {
+			return err
+		}
+		n.registerIptCleanFunc(func() error {
+			return fw.setMasquerade(config.BridgeName, pool, false)
+		})
+	}
+	return nil
+}
+
+// setExternalConnectivity allows, or disallows, traffic from the addresses of the endpoint to be forwarded out of the
+// host through its gateway address on the bridge, and traffic to the published ports of the endpoint to be forwarded
+// to it.
+func (d *bridgeDriver) setExternalConnectivity(config *networkConfiguration, ep *bridgeEndpoint, enable bool) error {
+	d.Lock()
+	fw := d.firewall
+	d.Unlock()
+
+	if fw == nil {
+		if len(ep.portMapping) > 0 {
+			return IPTableCfgError(config.BridgeName)
+		}
+		return nil
+	}
+
+	for _, addr := range []*net.IPNet{ep.addr, ep.addrv6} {
+		if addr == nil {
+			continue
+		}
+		if err := fw.setExternalForwarding(config.BridgeName, addr.IP, enable); err != nil {
+			return err
+		}
+	}
+	for _, binding := range ep.portMapping {
+		if err := fw.setPortMapping(config.BridgeName, binding, enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapPorts returns the port bindings requested for the endpoint, with the container address filled in: the IPv6
+// address of the endpoint for bindings to an IPv6 host address, or to any host address if the endpoint has no IPv4
+// address, and its IPv4 address otherwise. Host ports are reserved with the port allocator, so that no two endpoints
+// publish the same one: a free port of the ephemeral range is picked for bindings without a host port, and a free one
+// of the range for bindings with a host port range. Bindings to an IPv6 container address are rejected unless ipv6 is
+// set, as the firewall backend could not program them.
+func (ep *bridgeEndpoint) mapPorts(bindings []types.PortBinding, ipv6 bool) ([]types.PortBinding, error) {
+	out := make([]types.PortBinding, 0, len(bindings))
+	for _, binding := range bindings {
+		addr := ep.addr
+		anyHost := binding.HostIP == nil || binding.HostIP.IsUnspecified()
+		if !anyHost && binding.HostIP.To4() == nil || anyHost && ep.addr == nil {
+			addr = ep.addrv6
+		}
+		if addr == nil {
+			releasePorts(out)
+			return nil, types.BadRequestErrorf("endpoint %.7s has no address to publish port %d/%s on", ep.id, binding.Port, binding.Proto)
+		}
+		if addr.IP.To4() == nil && !ipv6 {
+			releasePorts(out)
+			return nil, types.BadRequestErrorf("cannot publish port %d/%s of endpoint %.7s over IPv6: the firewall backend does not support it", binding.Port, binding.Proto, ep.id)
+		}
+		binding.IP = addr.IP
+
+		portEnd := binding.HostPortEnd
+		if portEnd < binding.HostPort {
+			portEnd = binding.HostPort
+		}
+		port, err := portallocator.Get().RequestPortInRange(binding.HostIP, binding.Proto.String(), int(binding.HostPort), int(portEnd))
+		if err != nil {
+			releasePorts(out)
+			return nil, types.ForbiddenErrorf("failed to allocate a host port to publish port %d/%s of endpoint %.7s: %v", binding.Port, binding.Proto, ep.id, err)
+		}
+		binding.HostPort, binding.HostPortEnd = uint16(port), uint16(port)
+		out = append(out, binding)
+	}
+	return out, nil
+}
+
+// reservePorts reserves the host ports of bindings restored from the store with the port allocator.
+func reservePorts(bindings []types.PortBinding) error {
+	for i, binding := range bindings {
+		if _, err := portallocator.Get().RequestPort(binding.HostIP, binding.Proto.String(), int(binding.HostPort)); err != nil {
+			releasePorts(bindings[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePorts returns the host ports of the bindings to the port allocator.
+func releasePorts(bindings []types.PortBinding) {
+	for _, binding := range bindings {
+		portallocator.Get().ReleasePort(binding.HostIP, binding.Proto.String(), int(binding.HostPort))
+	}
+}
+
+// reconcileExternalConnectivity re-programs the external connectivity of the endpoints which had it. The host ports of
+// endpoints restored from the store are reserved again, while those of endpoints whose rules were flushed by a firewall
+// reload are still held.
+func (n *bridgeNetwork) reconcileExternalConnectivity(restored bool) {
+	n.Lock()
+	config := n.config
+	var eps []*bridgeEndpoint
+	for _, ep := range n.endpoints {
+		if ep.external {
+			eps = append(eps, ep)
+		}
+	}
+	n.Unlock()
+
+	for _, ep := range eps {
+		if restored {
+			if err := reservePorts(ep.portMapping); err != nil {
+				logrus.WithError(err).Warnf("Failed to reserve the published ports of endpoint %.7s: %v", ep.id, err)
+			}
+		}
+		if err := n.driver.setExternalConnectivity(config, ep, true); err != nil {
+			logrus.WithError(err).Warnf("Failed to restore external connectivity for endpoint %.7s: %v", ep.id, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package l2bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestProgramExternalConnectivityTwice(t *testing.T) {
+	fw := newTestFirewall()
+	config := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", HostGateway: true}
+	n := newTestFirewallNetwork(fw, config)
+	ep := &bridgeEndpoint{id: "endpoint0", addr: mustParseCIDR(t, "192.168.0.2/24")}
+	n.endpoints[ep.id] = ep
+
+	portMap := func(hostPort float64) map[string]interface{} {
+		return map[string]interface{}{netlabel.PortMap: []interface{}{
+			map[string]interface{}{"Proto": float64(6), "Port": float64(80), "HostPort": hostPort},
+		}}
+	}
+
+	if err := n.driver.ProgramExternalConnectivity(n.id, ep.id, portMap(8080)); err != nil {
+		t.Fatal(err)
+	}
+	// Programming the endpoint again replaces its mapping, releasing the host port it held.
+	if err := n.driver.ProgramExternalConnectivity(n.id, ep.id, portMap(8081)); err != nil {
+		t.Fatalf("Failed to program the endpoint again: %v", err)
+	}
+	if len(ep.portMapping) != 1 || ep.portMapping[0].HostPort != 8081 {
+		t.Errorf("ProgramExternalConnectivity() mapped %v, expected host port 8081", ep.portMapping)
+	}
+	for rule := range fw.rules {
+		if rule != "external l2b-test0 192.168.0.2" && rule != "port l2b-test0 "+ep.portMapping[0].String() {
+			t.Errorf("Rule %q of the replaced mapping was left programmed", rule)
+		}
+	}
+
+	if err := n.driver.RevokeExternalConnectivity(n.id, ep.id); err != nil {
+		t.Fatal(err)
+	}
+	if len(fw.rules) != 0 {
+		t.Errorf("Rules %v were left programmed after revoking the endpoint", fw.rules)
+	}
+	// Both host ports are free again.
+	if err := n.driver.ProgramExternalConnectivity(n.id, ep.id, portMap(8080)); err != nil {
+		t.Errorf("Host port 8080 was leaked: %v", err)
+	}
+	if err := n.driver.RevokeExternalConnectivity(n.id, ep.id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgramExternalConnectivityIPv6(t *testing.T) {
+	fw := newTestFirewall()
+	fw.noIPv6 = true
+	config := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", HostGateway: true}
+	n := newTestFirewallNetwork(fw, config)
+	ep := &bridgeEndpoint{id: "endpoint0", addr: mustParseCIDR(t, "192.168.0.2/24"), addrv6: mustParseCIDR(t, "2001:db8::2/64")}
+	n.endpoints[ep.id] = ep
+
+	v4 := map[string]interface{}{"Proto": float64(6), "Port": float64(80), "HostPort": float64(8080)}
+	v6 := map[string]interface{}{"Proto": float64(6), "Port": float64(80), "HostPort": float64(8080), "HostIP": "::1"}
+
+	err := n.driver.ProgramExternalConnectivity(n.id, ep.id, map[string]interface{}{netlabel.PortMap: []interface{}{v4, v6}})
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("ProgramExternalConnectivity() = %v, expected a bad request error", err)
+	}
+	if ep.external || len(fw.rules) != 0 {
+		t.Errorf("Rules %v were programmed for a rejected mapping", fw.rules)
+	}
+
+	// The host port reserved for the IPv4 binding was released.
+	if err := n.driver.ProgramExternalConnectivity(n.id, ep.id, map[string]interface{}{netlabel.PortMap: []interface{}{v4}}); err != nil {
+		t.Fatalf("Failed to publish the IPv4 port: %v", err)
+	}
+	if err := n.driver.RevokeExternalConnectivity(n.id, ep.id); err != nil {
+		t.Fatal(err)
+	}
+
+	// A backend programming IPv6 rules accepts the binding.
+	fw.noIPv6 = false
+	if err := n.driver.ProgramExternalConnectivity(n.id, ep.id, map[string]interface{}{netlabel.PortMap: []interface{}{v6}}); err != nil {
+		t.Fatalf("Failed to publish the IPv6 port: %v", err)
+	}
+	if err := n.driver.RevokeExternalConnectivity(n.id, ep.id); err != nil {
+		t.Fatal(err)
+	}
+}
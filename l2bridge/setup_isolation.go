@@ -0,0 +1,24 @@
+package l2bridge
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+)
+
+// isolated reports whether the endpoint port is isolated from the other endpoints of the network. On isolated
+// networks, every endpoint is isolated unless it is marked promiscuous.
+func (ep *bridgeEndpoint) isolated(config *networkConfiguration) bool {
+	return config.Isolated && (ep.config == nil || !ep.config.Promiscuous)
+}
+
+// setPortIsolation sets the isolated flag of the bridge port. Isolated ports may only exchange frames with ports that
+// are not isolated, such as uplinks and promiscuous endpoints.
+func setPortIsolation(link netlink.Link, isolated bool) error {
+	path := filepath.Join("/sys/class/net", link.Attrs().Name, "brport/isolated")
+	if err := setSysBoolParam(path, isolated); err != nil {
+		return fmt.Errorf("unable to set isolated flag on %s: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package l2bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// uplinkState records the state of a host interface before it was attached to a bridge as an uplink.
+type uplinkState struct {
+	Master string
+	Up     bool
+}
+
+// setupUplinks attaches the host interfaces configured as uplinks to the bridge. The state of each interface is
+// recorded on first attachment so it can be restored when the network is deleted.
+func setupUplinks(config *networkConfiguration, i *bridgeInterface) error {
+	for _, name := range config.Uplinks {
+		link, err := i.nlh.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("could not find uplink interface %s: %v", name, err)
+		}
+
+		if _, ok := config.uplinkStates[name]; !ok {
+			state := &uplinkState{Up: link.Attrs().Flags&net.FlagUp != 0}
+			if index := link.Attrs().MasterIndex; index != 0 {
+				master, err := i.nlh.LinkByIndex(index)
+				if err != nil {
+					return fmt.Errorf("could not find master of uplink interface %s: %v", name, err)
+				}
+				state.Master = master.Attrs().Name
+			}
+			if config.uplinkStates == nil {
+				config.uplinkStates = make(map[string]*uplinkState)
+			}
+			config.uplinkStates[name] = state
+		}
+
+		if err := addToBridge(i.nlh, name, config.BridgeName); err != nil {
+			return fmt.Errorf("adding uplink %s to bridge %s failed: %v", name, config.BridgeName, err)
+		}
+		if err := i.nlh.LinkSetUp(link); err != nil {
+			return fmt.Errorf("could not set link up for uplink interface %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// releaseUplinks detaches the uplinks from the bridge, restoring the state they were in before being attached.
+// It is a best effort, and failures are only logged.
+func releaseUplinks(nlh *netlink.Handle, config *networkConfiguration) {
+	for name, state := range config.uplinkStates {
+		link, err := nlh.LinkByName(name)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to find uplink interface %s on release: %v", name, err)
+			continue
+		}
+
+		if state.Master != "" {
+			err = addToBridge(nlh, name, state.Master)
+		} else {
+			err = nlh.LinkSetNoMaster(link)
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to restore master of uplink interface %s: %v", name, err)
+		}
+
+		if !state.Up {
+			if err := nlh.LinkSetDown(link); err != nil {
+				logrus.WithError(err).Warnf("Failed to set link down for uplink interface %s: %v", name, err)
+			}
+		}
+	}
+	config.uplinkStates = nil
+}
+
+// handOverUplinks moves the uplinks of a network being deleted to another network on the same bridge, which keeps them
+// attached until it is deleted in turn.
+func (d *bridgeDriver) handOverUplinks(config *networkConfiguration, n *bridgeNetwork) {
+	if len(config.Uplinks) == 0 {
+		return
+	}
+
+	n.Lock()
+	other := n.config
+	other.Uplinks = append(other.Uplinks, config.Uplinks...)
+	for name, state := range config.uplinkStates {
+		if other.uplinkStates == nil {
+			other.uplinkStates = make(map[string]*uplinkState)
+		}
+		other.uplinkStates[name] = state
+	}
+	n.Unlock()
+
+	logrus.Debugf("Handed uplinks %v of network %.7s to network %.7s", config.Uplinks, config.ID, other.ID)
+	config.Uplinks, config.uplinkStates = nil, nil
+	if err := d.storeUpdate(other); err != nil {
+		logrus.WithError(err).Warnf("Failed to update bridge network %.7s after handing it uplinks: %v", other.ID, err)
+	}
+}
+
+// checkUplinkConflicts ensures that none of the uplinks in config are already attached to another network.
+func (d *bridgeDriver) checkUplinkConflicts(config *networkConfiguration) error {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		other := n.config
+		n.Unlock()
+
+		for _, uplink := range config.Uplinks {
+			for _, otherUplink := range other.Uplinks {
+				if uplink == otherUplink {
+					return types.ForbiddenErrorf("uplink %s is already attached to network %s", uplink, other.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package l2bridge
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+// setupTestOSContext joins a new network namespace, as testutils.SetupTestOSContext does, and mounts the sysfs of that
+// namespace in a mount namespace of its own, as the setup steps read and write bridge attributes there.
+func setupTestOSContext(t *testing.T) func() {
+	teardown := testutils.SetupTestOSContext(t)
+	if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+		t.Fatalf("Failed to enter mount namespace: %v", err)
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		t.Fatalf("Failed to make mounts private: %v", err)
+	}
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", 0, ""); err != nil {
+		t.Fatalf("Failed to mount sysfs: %v", err)
+	}
+	return teardown
+}
+
+// newTestDriver returns a driver configured as the plugin would be, without a firewall unless config enables one.
+func newTestDriver(t *testing.T, config *Configuration) *bridgeDriver {
+	d := NewBridgeDriver(nil)
+	if err := d.configure(map[string]interface{}{netlabel.GenericData: config}); err != nil {
+		t.Fatalf("Failed to configure the driver: %v", err)
+	}
+	return d
+}
+
+// createTestNetwork creates a network with the configuration, addressed from the pool.
+func createTestNetwork(t *testing.T, d *bridgeDriver, config *networkConfiguration, pool string) error {
+	ipv4 := []*IPAMData{{Pool: mustParseCIDR(t, pool)}}
+	return d.CreateNetwork(config.ID, map[string]interface{}{netlabel.GenericData: config}, ipv4, nil)
+}
+
+func addTestLink(t *testing.T, link netlink.Link) netlink.Link {
+	if err := netlink.LinkAdd(link); err != nil {
+		t.Fatalf("Failed to create interface %s: %v", link.Attrs().Name, err)
+	}
+	created, err := netlink.LinkByName(link.Attrs().Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// addTestVeth creates a veth pair, whose first end stands in for a host interface.
+func addTestVeth(t *testing.T, name string) netlink.Link {
+	return addTestLink(t, &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"})
+}
+
+func masterName(t *testing.T, name string) string {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Attrs().MasterIndex == 0 {
+		return ""
+	}
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return master.Attrs().Name
+}
+
+func TestCreateNetworkUplinkWithoutStateDir(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer setupTestOSContext(t)()
+	}
+
+	addTestVeth(t, "uplink0")
+	d := newTestDriver(t, &Configuration{})
+
+	config := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", Uplinks: []string{"uplink0"}}
+	if err := createTestNetwork(t, d, config, "192.168.0.0/24"); err != nil {
+		t.Fatalf("Failed to create the network: %v", err)
+	}
+	if master := masterName(t, "uplink0"); master != config.BridgeName {
+		t.Errorf("Uplink is attached to %q, expected %q", master, config.BridgeName)
+	}
+
+	if err := d.DeleteNetwork(config.ID); err != nil {
+		t.Fatalf("Failed to delete the network: %v", err)
+	}
+	if master := masterName(t, "uplink0"); master != "" {
+		t.Errorf("Uplink is still attached to %q", master)
+	}
+}
@@ -0,0 +1,121 @@
+package l2bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// vlanIfaceName returns the name of the VLAN sub-interface uplink of the network.
+func (c *networkConfiguration) vlanIfaceName() string {
+	return fmt.Sprintf("%s.%d", c.Parent, c.VlanID)
+}
+
+// setupVlan creates an 802.1Q sub-interface on the parent interface and attaches it to the bridge. An existing
+// sub-interface with the expected parent and VLAN ID is reused, and will be left in place when the network is deleted.
+func setupVlan(config *networkConfiguration, i *bridgeInterface) error {
+	name := config.vlanIfaceName()
+
+	parent, err := i.nlh.LinkByName(config.Parent)
+	if err != nil {
+		return fmt.Errorf("could not find parent interface %s: %v", config.Parent, err)
+	}
+
+	link, err := i.nlh.LinkByName(name)
+	if err != nil {
+		link = &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index},
+			VlanId:    config.VlanID,
+		}
+		if err := i.nlh.LinkAdd(link); err != nil {
+			return fmt.Errorf("failed to create VLAN sub-interface %s: %v", name, err)
+		}
+		config.vlanCreated = true
+		logrus.Debugf("Created VLAN sub-interface %s", name)
+	} else if vlan, ok := link.(*netlink.Vlan); !ok || vlan.VlanId != config.VlanID || vlan.ParentIndex != parent.Attrs().Index {
+		return fmt.Errorf("existing interface %s is not VLAN %d of %s", name, config.VlanID, config.Parent)
+	}
+
+	if err := i.nlh.LinkSetUp(parent); err != nil {
+		return fmt.Errorf("could not set link up for parent interface %s: %v", config.Parent, err)
+	}
+	if err := addToBridge(i.nlh, name, config.BridgeName); err != nil {
+		return fmt.Errorf("adding VLAN sub-interface %s to bridge %s failed: %v", name, config.BridgeName, err)
+	}
+	if err := i.nlh.LinkSetUp(link); err != nil {
+		return fmt.Errorf("could not set link up for VLAN sub-interface %s: %v", name, err)
+	}
+	return nil
+}
+
+// releaseVlan removes the VLAN sub-interface uplink if it was created by the driver, or detaches it from the bridge
+// otherwise. It is a best effort, and failures are only logged.
+func releaseVlan(nlh *netlink.Handle, config *networkConfiguration) {
+	if config.Parent == "" {
+		return
+	}
+
+	name := config.vlanIfaceName()
+	link, err := nlh.LinkByName(name)
+	if err != nil {
+		logrus.WithError(err).Debugf("Failed to find VLAN sub-interface %s on release: %v", name, err)
+		return
+	}
+
+	if config.vlanCreated {
+		err = nlh.LinkDel(link)
+	} else {
+		err = nlh.LinkSetNoMaster(link)
+	}
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to release VLAN sub-interface %s: %v", name, err)
+		return
+	}
+	config.vlanCreated = false
+}
+
+// checkVlanConflicts ensures that no other network uses the same parent interface and VLAN ID as config, be it through
+// a VLAN sub-interface of its own or an uplink, and that the VLANs of config are not already trunked in another way.
+func (d *bridgeDriver) checkVlanConflicts(config *networkConfiguration) error {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		other := n.config
+		n.Unlock()
+
+		if config.Parent != "" && other.Parent == config.Parent && other.VlanID == config.VlanID {
+			return types.ForbiddenErrorf("VLAN %d on %s is already used by network %s", config.VlanID, config.Parent, other.ID)
+		}
+		if err := d.checkVlanUplinks(config, other); err != nil {
+			return err
+		}
+		if err := d.checkVlanUplinks(other, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVlanUplinks ensures that the VLAN sub-interface of one network does not carry a VLAN which the uplinks of
+// another network carry as well: either as a sub-interface of the same VLAN, or as a trunk of a VLAN filtering bridge
+// tagging its VLANs onto the parent interface.
+func (d *bridgeDriver) checkVlanUplinks(vlan, uplinks *networkConfiguration) error {
+	if vlan.Parent == "" {
+		return nil
+	}
+
+	for _, name := range uplinks.Uplinks {
+		seg := d.uplinkSegment(name)
+		if seg.dev != vlan.Parent {
+			continue
+		}
+		if seg.vlan == vlan.VlanID {
+			return types.ForbiddenErrorf("VLAN %d on %s of network %s is also attached by uplink %s of network %s", vlan.VlanID, vlan.Parent, vlan.ID, name, uplinks.ID)
+		}
+		if seg.vlan == 0 && uplinks.VlanFiltering {
+			return types.ForbiddenErrorf("VLAN %d on %s of network %s may also be trunked by uplink %s of VLAN filtering network %s", vlan.VlanID, vlan.Parent, vlan.ID, name, uplinks.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package l2bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// defaultVlanID is the VLAN to which the kernel assigns ports of a VLAN filtering bridge, untagged.
+const defaultVlanID = 1
+
+// vlanID returns the access VLAN of the endpoint.
+func (ep *bridgeEndpoint) vlanID() int {
+	if ep.config != nil && ep.config.VlanID != 0 {
+		return ep.config.VlanID
+	}
+	return defaultVlanID
+}
+
+// setupVlanFiltering enables VLAN filtering on the bridge.
+func setupVlanFiltering(config *networkConfiguration, i *bridgeInterface) error {
+	path := fmt.Sprintf("/sys/class/net/%s/bridge/vlan_filtering", config.BridgeName)
+	if err := setSysBoolParam(path, true); err != nil {
+		return fmt.Errorf("failed to enable VLAN filtering on bridge %s: %v", config.BridgeName, err)
+	}
+	return nil
+}
+
+// setupAccessVlan makes the host side interface of an endpoint an untagged access port on the endpoint's VLAN, and
+// adds the VLAN to the trunk uplinks of the bridge.
+func (d *bridgeDriver) setupAccessVlan(config *networkConfiguration, ep *bridgeEndpoint, host netlink.Link) error {
+	vid := ep.vlanID()
+	if vid != defaultVlanID {
+		if err := d.nlh.BridgeVlanDel(host, defaultVlanID, true, true, false, true); err != nil {
+			return fmt.Errorf("failed to remove interface %s from the default VLAN: %v", host.Attrs().Name, err)
+		}
+		if err := d.nlh.BridgeVlanAdd(host, uint16(vid), true, true, false, true); err != nil {
+			return fmt.Errorf("failed to add interface %s to VLAN %d: %v", host.Attrs().Name, vid, err)
+		}
+	}
+	return d.syncTrunks(config.BridgeName)
+}
+
+// syncTrunks adds every VLAN used by an endpoint on the named bridge, tagged, to every uplink attached to the bridge.
+func (d *bridgeDriver) syncTrunks(bridgeName string) error {
+	var uplinks []string
+	vids := make(map[int]bool)
+	for _, n := range d.getBridgeNetworks(bridgeName) {
+		n.Lock()
+		uplinks = append(uplinks, n.config.Uplinks...)
+		for _, ep := range n.endpoints {
+			vids[ep.vlanID()] = true
+		}
+		n.Unlock()
+	}
+
+	for _, name := range uplinks {
+		link, err := d.nlh.LinkByName(name)
+		if err != nil {
+			return fmt.Errorf("could not find uplink interface %s: %v", name, err)
+		}
+		for vid := range vids {
+			if vid == defaultVlanID {
+				continue
+			}
+			if err := d.nlh.BridgeVlanAdd(link, uint16(vid), false, false, false, true); err != nil {
+				return fmt.Errorf("failed to add VLAN %d to uplink %s: %v", vid, name, err)
+			}
+		}
+		logrus.Debugf("Trunked %d VLANs on uplink %s", len(vids), name)
+	}
+	return nil
+}
+
+// checkBridgeSharing ensures that a network only shares its bridge with other networks if they all filter VLANs.
+func (d *bridgeDriver) checkBridgeSharing(config *networkConfiguration) error {
+	shared := d.getBridgeNetworks(config.BridgeName)
+	for _, n := range shared {
+		n.Lock()
+		other := n.config
+		n.Unlock()
+
+		if !config.VlanFiltering || !other.VlanFiltering {
+			return types.ForbiddenErrorf("bridge %s is already used by network %s", config.BridgeName, other.ID)
+		}
+	}
+
+	if len(shared) == 0 && config.VlanFiltering {
+		exists, err := bridgeInterfaceExists(config.BridgeName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return types.ForbiddenErrorf("interface with name %s exists", config.BridgeName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package l2bridge
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+// requireVlanFiltering skips the test on kernels built without VLAN filtering on bridges.
+func requireVlanFiltering(t *testing.T) {
+	bridge := addTestLink(t, &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "l2b-probe"}})
+	defer netlink.LinkDel(bridge)
+
+	if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/bridge/vlan_filtering", bridge.Attrs().Name)); err != nil {
+		t.Skip("The kernel does not support VLAN filtering on bridges")
+	}
+}
+
+func TestDeleteNetworkKeepsSharedUplink(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer setupTestOSContext(t)()
+	}
+	requireVlanFiltering(t)
+
+	addTestVeth(t, "uplink0")
+	d := newTestDriver(t, &Configuration{})
+
+	trunk := &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", VlanFiltering: true, Uplinks: []string{"uplink0"}}
+	if err := createTestNetwork(t, d, trunk, "192.168.0.0/24"); err != nil {
+		t.Fatalf("Failed to create the network: %v", err)
+	}
+	other := &networkConfiguration{ID: "network1", BridgeName: "l2b-test0", VlanFiltering: true}
+	if err := createTestNetwork(t, d, other, "192.168.1.0/24"); err != nil {
+		t.Fatalf("Failed to create the network sharing the bridge: %v", err)
+	}
+
+	// The uplink declared by the first network keeps serving the second one.
+	if err := d.DeleteNetwork(trunk.ID); err != nil {
+		t.Fatalf("Failed to delete the network: %v", err)
+	}
+	if master := masterName(t, "uplink0"); master != "l2b-test0" {
+		t.Errorf("Uplink is attached to %q while a network remains on the bridge", master)
+	}
+	if len(other.Uplinks) != 1 || other.uplinkStates["uplink0"] == nil {
+		t.Errorf("The uplink was not handed to the remaining network: %v", other.Uplinks)
+	}
+
+	if err := d.DeleteNetwork(other.ID); err != nil {
+		t.Fatalf("Failed to delete the network: %v", err)
+	}
+	if master := masterName(t, "uplink0"); master != "" {
+		t.Errorf("Uplink is still attached to %q after the last network was deleted", master)
+	}
+}
@@ -0,0 +1,91 @@
+package l2bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestCheckVlanConflicts(t *testing.T) {
+	d := NewBridgeDriver(&Configuration{})
+	d.initHandle()
+	for _, existing := range []*networkConfiguration{
+		{ID: "vlan", BridgeName: "l2b-test0", Parent: "l2b-eth0", VlanID: 10},
+		{ID: "filtering", BridgeName: "l2b-test1", VlanFiltering: true, Uplinks: []string{"l2b-eth1"}},
+		{ID: "untagged", BridgeName: "l2b-test2", Uplinks: []string{"l2b-eth2"}},
+	} {
+		d.networks[existing.ID] = &bridgeNetwork{id: existing.ID, config: existing}
+	}
+
+	tests := []struct {
+		name    string
+		config  *networkConfiguration
+		wantErr bool
+	}{
+		{
+			name:   "other VLAN",
+			config: &networkConfiguration{Parent: "l2b-eth0", VlanID: 20},
+		},
+		{
+			name:    "same VLAN",
+			config:  &networkConfiguration{Parent: "l2b-eth0", VlanID: 10},
+			wantErr: true,
+		},
+		{
+			name:    "VLAN on a trunk",
+			config:  &networkConfiguration{Parent: "l2b-eth1", VlanID: 10},
+			wantErr: true,
+		},
+		{
+			name:   "VLAN on an untagged uplink",
+			config: &networkConfiguration{Parent: "l2b-eth2", VlanID: 10},
+		},
+		{
+			name:    "trunk of a VLAN",
+			config:  &networkConfiguration{BridgeName: "l2b-test3", VlanFiltering: true, Uplinks: []string{"l2b-eth0"}},
+			wantErr: true,
+		},
+		{
+			name:   "untagged uplink of a VLAN",
+			config: &networkConfiguration{BridgeName: "l2b-test3", Uplinks: []string{"l2b-eth0"}},
+		},
+	}
+
+	for _, tt := range tests {
+		err := d.checkVlanConflicts(tt.config)
+		if _, ok := err.(types.ForbiddenError); tt.wantErr && !ok {
+			t.Errorf("%s: checkVlanConflicts() = %v, expected a forbidden error", tt.name, err)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%s: checkVlanConflicts() failed: %v", tt.name, err)
+		}
+	}
+}
+
+func TestCheckVlanConflictsSubInterfaceUplink(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer setupTestOSContext(t)()
+	}
+
+	parent := addTestVeth(t, "l2b-eth0")
+	vlan := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "l2b-eth0.10", ParentIndex: parent.Attrs().Index}, VlanId: 10}
+	if err := netlink.LinkAdd(vlan); err != nil {
+		t.Skipf("The kernel does not support VLAN sub-interfaces: %v", err)
+	}
+
+	d := NewBridgeDriver(&Configuration{})
+	d.initHandle()
+	existing := &networkConfiguration{ID: "uplink", BridgeName: "l2b-test0", Uplinks: []string{"l2b-eth0.10"}}
+	d.networks[existing.ID] = &bridgeNetwork{id: existing.ID, config: existing}
+
+	// The sub-interface the driver would create for the VLAN is already the uplink of another network.
+	err := d.checkVlanConflicts(&networkConfiguration{Parent: "l2b-eth0", VlanID: 10})
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Errorf("checkVlanConflicts() = %v, expected a forbidden error", err)
+	}
+	if err := d.checkVlanConflicts(&networkConfiguration{Parent: "l2b-eth0", VlanID: 20}); err != nil {
+		t.Errorf("checkVlanConflicts() failed: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package l2bridge
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	vxlanPrefix = "vx-"
+	vxlanPort   = 4789
+	maxVxlanID  = 1<<24 - 1
+)
+
+// vxlanIfaceName returns the name of the VXLAN uplink of the network.
+func (c *networkConfiguration) vxlanIfaceName() string {
+	return vxlanPrefix + c.ID[:12]
+}
+
+// setupVxlan creates a VXLAN device and attaches it to the bridge. When static peers are configured, an all-zeros
+// FDB entry is added for each of them so that broadcast and unknown unicast traffic is replicated to every peer.
+func setupVxlan(config *networkConfiguration, i *bridgeInterface) error {
+	name := config.vxlanIfaceName()
+
+	link, err := i.nlh.LinkByName(name)
+	if err != nil {
+		vxlan := &netlink.Vxlan{
+			LinkAttrs: netlink.LinkAttrs{Name: name, MTU: config.Mtu},
+			VxlanId:   config.VxlanID,
+			Group:     config.VxlanGroup,
+			Port:      vxlanPort,
+			Learning:  true,
+		}
+		if config.VxlanDev != "" {
+			dev, err := i.nlh.LinkByName(config.VxlanDev)
+			if err != nil {
+				return fmt.Errorf("could not find VXLAN device %s: %v", config.VxlanDev, err)
+			}
+			vxlan.VtepDevIndex = dev.Attrs().Index
+		}
+		if err := i.nlh.LinkAdd(vxlan); err != nil {
+			return fmt.Errorf("failed to create VXLAN interface %s: %v", name, err)
+		}
+		link = vxlan
+		logrus.Debugf("Created VXLAN interface %s with VNI %d", name, config.VxlanID)
+	} else if _, ok := link.(*netlink.Vxlan); !ok {
+		return fmt.Errorf("existing interface %s is not a VXLAN interface", name)
+	}
+
+	if err := addToBridge(i.nlh, name, config.BridgeName); err != nil {
+		return fmt.Errorf("adding VXLAN interface %s to bridge %s failed: %v", name, config.BridgeName, err)
+	}
+	if err := i.nlh.LinkSetUp(link); err != nil {
+		return fmt.Errorf("could not set link up for VXLAN interface %s: %v", name, err)
+	}
+
+	for _, remote := range config.VxlanRemotes {
+		if err := addVxlanPeer(i.nlh, link, remote); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addVxlanPeer adds an all-zeros FDB entry pointing at the remote VTEP. Adding an existing entry is not an error.
+func addVxlanPeer(nlh *netlink.Handle, link netlink.Link, remote net.IP) error {
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		State:        netlink.NUD_PERMANENT | netlink.NUD_NOARP,
+		Flags:        netlink.NTF_SELF,
+		IP:           remote,
+		HardwareAddr: make(net.HardwareAddr, 6),
+	}
+	if err := nlh.NeighAppend(neigh); err != nil && err != syscall.EEXIST {
+		return fmt.Errorf("failed to add VXLAN peer %s to %s: %v", remote, link.Attrs().Name, err)
+	}
+	return nil
+}
+
+// releaseVxlan removes the VXLAN uplink, along with its FDB entries. It is a best effort, and failures are only logged.
+func releaseVxlan(nlh *netlink.Handle, config *networkConfiguration) {
+	if config.VxlanID == 0 {
+		return
+	}
+
+	name := config.vxlanIfaceName()
+	link, err := nlh.LinkByName(name)
+	if err != nil {
+		logrus.WithError(err).Debugf("Failed to find VXLAN interface %s on release: %v", name, err)
+		return
+	}
+	if err := nlh.LinkDel(link); err != nil {
+		logrus.WithError(err).Warnf("Failed to remove VXLAN interface %s: %v", name, err)
+	}
+}
+
+// checkVxlanConflicts ensures that no other network uses the same VNI as config.
+func (d *bridgeDriver) checkVxlanConflicts(config *networkConfiguration) error {
+	if config.VxlanID == 0 {
+		return nil
+	}
+
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		other := n.config
+		n.Unlock()
+
+		if other.VxlanID == config.VxlanID {
+			return types.ForbiddenErrorf("VNI %d is already used by network %s", config.VxlanID, other.ID)
+		}
+	}
+	return nil
+}
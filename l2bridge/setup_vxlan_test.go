@@ -0,0 +1,100 @@
+package l2bridge
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestValidateVxlan(t *testing.T) {
+	remotes := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}
+
+	tests := []struct {
+		name    string
+		config  *networkConfiguration
+		wantErr bool
+	}{
+		{name: "no VXLAN", config: &networkConfiguration{}},
+		{name: "remotes", config: &networkConfiguration{VxlanID: 42, VxlanRemotes: remotes}},
+		{name: "remotes on a device", config: &networkConfiguration{VxlanID: 42, VxlanRemotes: remotes, VxlanDev: "eth0"}},
+		{name: "group", config: &networkConfiguration{VxlanID: 42, VxlanGroup: net.ParseIP("239.1.1.1"), VxlanDev: "eth0"}},
+		{name: "IPv6 group", config: &networkConfiguration{VxlanID: maxVxlanID, VxlanGroup: net.ParseIP("ff05::1"), VxlanDev: "eth0"}},
+		{name: "no VNI", config: &networkConfiguration{VxlanRemotes: remotes}, wantErr: true},
+		{name: "VNI too large", config: &networkConfiguration{VxlanID: maxVxlanID + 1, VxlanRemotes: remotes}, wantErr: true},
+		{name: "negative VNI", config: &networkConfiguration{VxlanID: -1, VxlanRemotes: remotes}, wantErr: true},
+		{name: "neither remotes nor group", config: &networkConfiguration{VxlanID: 42}, wantErr: true},
+		{name: "device only", config: &networkConfiguration{VxlanID: 42, VxlanDev: "eth0"}, wantErr: true},
+		{
+			name:    "remotes and group",
+			config:  &networkConfiguration{VxlanID: 42, VxlanRemotes: remotes, VxlanGroup: net.ParseIP("239.1.1.1"), VxlanDev: "eth0"},
+			wantErr: true,
+		},
+		{name: "unicast group", config: &networkConfiguration{VxlanID: 42, VxlanGroup: net.ParseIP("192.0.2.1"), VxlanDev: "eth0"}, wantErr: true},
+		{name: "group without a device", config: &networkConfiguration{VxlanID: 42, VxlanGroup: net.ParseIP("239.1.1.1")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := tt.config.Validate()
+		if tt.wantErr {
+			if _, ok := err.(ErrInvalidVxlan); !ok {
+				t.Errorf("%s: Validate() = %v, expected an invalid VXLAN configuration error", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Validate() failed: %v", tt.name, err)
+		}
+	}
+}
+
+func TestSetupVxlan(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer setupTestOSContext(t)()
+	}
+
+	d := newTestDriver(t, &Configuration{})
+	remotes := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")}
+	config := &networkConfiguration{ID: "network000000", BridgeName: "l2b-test0", VxlanID: 42, VxlanRemotes: remotes}
+	if err := createTestNetwork(t, d, config, "192.168.0.0/24"); err != nil {
+		t.Fatalf("Failed to create the network: %v", err)
+	}
+
+	name := config.vxlanIfaceName()
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatalf("The VXLAN interface was not created: %v", err)
+	}
+	if vxlan, ok := link.(*netlink.Vxlan); !ok || vxlan.VxlanId != config.VxlanID || vxlan.Port != vxlanPort {
+		t.Errorf("Interface %s = %+v, expected VNI %d on port %d", name, link, config.VxlanID, vxlanPort)
+	}
+	if master := masterName(t, name); master != config.BridgeName {
+		t.Errorf("VXLAN interface is attached to %q, expected %q", master, config.BridgeName)
+	}
+
+	// Broadcast and unknown unicast traffic is replicated to every remote through an all-zeros entry.
+	neighs, err := netlink.NeighList(link.Attrs().Index, syscall.AF_BRIDGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, neigh := range neighs {
+		if neigh.HardwareAddr.String() == "00:00:00:00:00:00" && neigh.IP != nil {
+			found[neigh.IP.String()] = true
+		}
+	}
+	for _, remote := range remotes {
+		if !found[remote.String()] {
+			t.Errorf("No forwarding database entry for remote %s, got %v", remote, neighs)
+		}
+	}
+
+	if err := d.DeleteNetwork(config.ID); err != nil {
+		t.Fatalf("Failed to delete the network: %v", err)
+	}
+	if _, err := netlink.LinkByName(name); err == nil {
+		t.Error("The VXLAN interface was not removed")
+	}
+}
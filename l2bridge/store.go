@@ -0,0 +1,540 @@
+package l2bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultStateDir is the directory in which network and endpoint state is persisted.
+	DefaultStateDir = "/var/lib/l2bridge"
+
+	networkStorePrefix  = "network"
+	endpointStorePrefix = "endpoint"
+	storeFileExt        = ".json"
+)
+
+// storeObject is a record which can be persisted in the local store.
+type storeObject interface {
+	json.Marshaler
+	storePrefix() string
+	storeID() string
+}
+
+// localStore persists driver state as JSON files on disk, one file per record, so that networks and endpoints
+// survive a restart of the plugin.
+type localStore struct {
+	root string
+}
+
+func newLocalStore(root string) (*localStore, error) {
+	for _, prefix := range []string{networkStorePrefix, endpointStorePrefix, allocationStorePrefix, ipamPoolStorePrefix} {
+		if err := os.MkdirAll(filepath.Join(root, prefix), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create store directory: %v", err)
+		}
+	}
+	return &localStore{root: root}, nil
+}
+
+func (s *localStore) path(prefix, id string) string {
+	return filepath.Join(s.root, prefix, id+storeFileExt)
+}
+
+// put writes the record to disk, atomically replacing any previous version of it.
+func (s *localStore) put(obj storeObject) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(obj.storePrefix(), obj.storeID())
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+obj.storeID())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// delete removes the record from disk. Deleting a record which does not exist is not an error.
+func (s *localStore) delete(obj storeObject) error {
+	if err := os.Remove(s.path(obj.storePrefix(), obj.storeID())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// list returns the raw contents of every record stored under the given prefix.
+func (s *localStore) list(prefix string) ([][]byte, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.root, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var out [][]byte
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), storeFileExt) || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.root, prefix, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+func (d *bridgeDriver) initStore() error {
+	d.Lock()
+	dir := d.config.StateDir
+	d.Unlock()
+
+	if dir == "" {
+		logrus.Warn("No state directory configured, networks will not persist across restarts")
+		return nil
+	}
+
+	store, err := newLocalStore(dir)
+	if err != nil {
+		return types.InternalErrorf("l2bridge driver failed to initialize data store: %v", err)
+	}
+
+	d.Lock()
+	d.store = store
+	d.Unlock()
+
+	if err := d.populateNetworks(); err != nil {
+		return err
+	}
+	if err := d.populateEndpoints(); err != nil {
+		return err
+	}
+	if err := d.populateAllocations(); err != nil {
+		return err
+	}
+
+	d.reconcile()
+	return nil
+}
+
+func (d *bridgeDriver) populateNetworks() error {
+	d.initHandle()
+
+	records, err := d.store.list(networkStorePrefix)
+	if err != nil {
+		return types.InternalErrorf("failed to get bridge network configurations from store: %v", err)
+	}
+
+	for _, data := range records {
+		ncfg := &networkConfiguration{}
+		if err := json.Unmarshal(data, ncfg); err != nil {
+			logrus.WithError(err).Warnf("Failed to decode bridge network record from store")
+			continue
+		}
+		// Kernel state for the network is brought up to date by reconcile, once all endpoints are known.
+		d.Lock()
+		d.networks[ncfg.ID] = &bridgeNetwork{
+			id:        ncfg.ID,
+			endpoints: make(map[string]*bridgeEndpoint),
+			config:    ncfg,
+			bridge:    &bridgeInterface{nlh: d.nlh},
+			driver:    d,
+		}
+		d.Unlock()
+		logrus.Debugf("Network (%.7s) restored", ncfg.ID)
+	}
+	return nil
+}
+
+func (d *bridgeDriver) populateEndpoints() error {
+	records, err := d.store.list(endpointStorePrefix)
+	if err != nil {
+		return types.InternalErrorf("failed to get bridge endpoints from store: %v", err)
+	}
+
+	for _, data := range records {
+		ep := &bridgeEndpoint{}
+		if err := json.Unmarshal(data, ep); err != nil {
+			logrus.WithError(err).Warnf("Failed to decode bridge endpoint record from store")
+			continue
+		}
+
+		d.Lock()
+		n, ok := d.networks[ep.nid]
+		d.Unlock()
+
+		if !ok {
+			logrus.Debugf("Network (%.7s) not found for restored bridge endpoint (%.7s)", ep.nid, ep.id)
+			logrus.Debugf("Deleting stale bridge endpoint (%.7s) from store", ep.id)
+			if err := d.storeDelete(ep); err != nil {
+				logrus.WithError(err).Debugf("Failed to delete stale bridge endpoint (%.7s) from store", ep.id)
+			}
+			continue
+		}
+
+		n.Lock()
+		n.endpoints[ep.id] = ep
+		n.Unlock()
+		logrus.Debugf("Endpoint (%.7s) restored to network (%.7s)", ep.id, ep.nid)
+	}
+	return nil
+}
+
+func (d *bridgeDriver) storeUpdate(obj storeObject) error {
+	d.Lock()
+	store := d.store
+	d.Unlock()
+
+	if store == nil {
+		logrus.Debugf("bridge store not initialized. %s %s cannot be saved", obj.storePrefix(), obj.storeID())
+		return nil
+	}
+
+	if err := store.put(obj); err != nil {
+		return fmt.Errorf("failed to update bridge store for %s %s: %v", obj.storePrefix(), obj.storeID(), err)
+	}
+	return nil
+}
+
+func (d *bridgeDriver) storeDelete(obj storeObject) error {
+	d.Lock()
+	store := d.store
+	d.Unlock()
+
+	if store == nil {
+		logrus.Debugf("bridge store not initialized. %s %s cannot be deleted", obj.storePrefix(), obj.storeID())
+		return nil
+	}
+
+	if err := store.delete(obj); err != nil {
+		return fmt.Errorf("failed to delete %s %s from bridge store: %v", obj.storePrefix(), obj.storeID(), err)
+	}
+	return nil
+}
+
+func (ncfg *networkConfiguration) storePrefix() string {
+	return networkStorePrefix
+}
+
+func (ncfg *networkConfiguration) storeID() string {
+	return ncfg.ID
+}
+
+func (ncfg *networkConfiguration) MarshalJSON() ([]byte, error) {
+	nMap := make(map[string]interface{})
+	nMap["ID"] = ncfg.ID
+	nMap["BridgeName"] = ncfg.BridgeName
+	nMap["EnableIPv6"] = ncfg.EnableIPv6
+	nMap["Mtu"] = ncfg.Mtu
+	nMap["ContainerIfacePrefix"] = ncfg.ContainerIfacePrefix
+	nMap["Uplinks"] = ncfg.Uplinks
+	nMap["UplinkStates"] = ncfg.uplinkStates
+	nMap["Parent"] = ncfg.Parent
+	nMap["VlanID"] = ncfg.VlanID
+	nMap["VlanCreated"] = ncfg.vlanCreated
+	nMap["VlanFiltering"] = ncfg.VlanFiltering
+	nMap["StaticFdb"] = ncfg.StaticFdb
+	nMap["Antispoof"] = ncfg.Antispoof
+	nMap["Isolated"] = ncfg.Isolated
+	nMap["HostGateway"] = ncfg.HostGateway
+	nMap["Masquerade"] = ncfg.Masquerade
+	nMap["NullIPAM"] = ncfg.NullIPAM
+	nMap["IPv6AddrGenMode"] = ncfg.IPv6AddrGenMode
+	nMap["DHCP"] = ncfg.DHCP
+	if len(ncfg.DHCPDNS) > 0 {
+		servers := make([]string, 0, len(ncfg.DHCPDNS))
+		for _, server := range ncfg.DHCPDNS {
+			servers = append(servers, server.String())
+		}
+		nMap["DHCPDNS"] = servers
+	}
+	nMap["VxlanID"] = ncfg.VxlanID
+	nMap["VxlanDev"] = ncfg.VxlanDev
+	if ncfg.VxlanGroup != nil {
+		nMap["VxlanGroup"] = ncfg.VxlanGroup.String()
+	}
+	if len(ncfg.Peers) > 0 {
+		nMap["Peers"] = ncfg.Peers
+	}
+	if len(ncfg.Routes) > 0 {
+		nMap["Routes"] = formatRoutes(ncfg.Routes)
+	}
+	if len(ncfg.VxlanRemotes) > 0 {
+		remotes := make([]string, 0, len(ncfg.VxlanRemotes))
+		for _, remote := range ncfg.VxlanRemotes {
+			remotes = append(remotes, remote.String())
+		}
+		nMap["VxlanRemotes"] = remotes
+	}
+
+	if len(ncfg.SubnetsIPv4) > 0 {
+		nMap["SubnetsIPv4"] = ncfg.SubnetsIPv4
+	}
+	if len(ncfg.SubnetsIPv6) > 0 {
+		nMap["SubnetsIPv6"] = ncfg.SubnetsIPv6
+	}
+	if ncfg.DefaultGatewayIPv4 != nil {
+		nMap["DefaultGatewayIPv4"] = ncfg.DefaultGatewayIPv4.String()
+	}
+	if ncfg.DefaultGatewayIPv6 != nil {
+		nMap["DefaultGatewayIPv6"] = ncfg.DefaultGatewayIPv6.String()
+	}
+
+	return json.Marshal(nMap)
+}
+
+func (ncfg *networkConfiguration) UnmarshalJSON(b []byte) error {
+	var (
+		err  error
+		nMap map[string]interface{}
+	)
+
+	if err = json.Unmarshal(b, &nMap); err != nil {
+		return err
+	}
+
+	if v, ok := nMap["DefaultGatewayIPv4"]; ok {
+		ncfg.DefaultGatewayIPv4 = net.ParseIP(v.(string))
+	}
+	if v, ok := nMap["DefaultGatewayIPv6"]; ok {
+		ncfg.DefaultGatewayIPv6 = net.ParseIP(v.(string))
+	}
+	if v, ok := nMap["SubnetsIPv4"]; ok {
+		d, _ := json.Marshal(v)
+		if err = json.Unmarshal(d, &ncfg.SubnetsIPv4); err != nil {
+			return err
+		}
+	}
+	if v, ok := nMap["SubnetsIPv6"]; ok {
+		d, _ := json.Marshal(v)
+		if err = json.Unmarshal(d, &ncfg.SubnetsIPv6); err != nil {
+			return err
+		}
+	}
+
+	// Networks stored before multiple subnets were supported have a single pool, whose gateway is the default one.
+	if v, ok := nMap["PoolIPv4"]; ok {
+		pool, err := types.ParseCIDR(v.(string))
+		if err != nil {
+			return types.InternalErrorf("failed to decode bridge network IPv4 pool %s after json unmarshal: %v", v.(string), err)
+		}
+		ncfg.SubnetsIPv4 = []*subnet{{Pool: pool, Gateway: ncfg.DefaultGatewayIPv4}}
+	}
+	if v, ok := nMap["PoolIPv6"]; ok {
+		pool, err := types.ParseCIDR(v.(string))
+		if err != nil {
+			return types.InternalErrorf("failed to decode bridge network IPv6 pool %s after json unmarshal: %v", v.(string), err)
+		}
+		ncfg.SubnetsIPv6 = []*subnet{{Pool: pool, Gateway: ncfg.DefaultGatewayIPv6}}
+	}
+
+	ncfg.ID = nMap["ID"].(string)
+	ncfg.BridgeName = nMap["BridgeName"].(string)
+	ncfg.EnableIPv6 = nMap["EnableIPv6"].(bool)
+	ncfg.Mtu = int(nMap["Mtu"].(float64))
+	if v, ok := nMap["ContainerIfacePrefix"]; ok {
+		ncfg.ContainerIfacePrefix = v.(string)
+	}
+
+	if v, ok := nMap["Parent"]; ok {
+		ncfg.Parent = v.(string)
+	}
+	if v, ok := nMap["VlanID"]; ok {
+		ncfg.VlanID = int(v.(float64))
+	}
+	if v, ok := nMap["VlanCreated"]; ok {
+		ncfg.vlanCreated = v.(bool)
+	}
+	if v, ok := nMap["VlanFiltering"]; ok {
+		ncfg.VlanFiltering = v.(bool)
+	}
+	if v, ok := nMap["StaticFdb"]; ok {
+		ncfg.StaticFdb = v.(bool)
+	}
+	if v, ok := nMap["Antispoof"]; ok {
+		ncfg.Antispoof = v.(bool)
+	}
+	if v, ok := nMap["Isolated"]; ok {
+		ncfg.Isolated = v.(bool)
+	}
+	if v, ok := nMap["HostGateway"]; ok {
+		ncfg.HostGateway = v.(bool)
+	}
+	if v, ok := nMap["Masquerade"]; ok {
+		ncfg.Masquerade = v.(bool)
+	}
+	if v, ok := nMap["NullIPAM"]; ok {
+		ncfg.NullIPAM = v.(bool)
+	}
+	if v, ok := nMap["IPv6AddrGenMode"]; ok {
+		ncfg.IPv6AddrGenMode = v.(string)
+	}
+	if v, ok := nMap["DHCP"]; ok {
+		ncfg.DHCP = v.(bool)
+	}
+	if v, ok := nMap["DHCPDNS"]; ok {
+		for _, server := range v.([]interface{}) {
+			ncfg.DHCPDNS = append(ncfg.DHCPDNS, net.ParseIP(server.(string)))
+		}
+	}
+	if v, ok := nMap["Routes"]; ok {
+		if ncfg.Routes, err = parseRoutes(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge network routes: %v", err)
+		}
+	}
+	if v, ok := nMap["Peers"]; ok {
+		for _, peer := range v.([]interface{}) {
+			ncfg.Peers = append(ncfg.Peers, peer.(string))
+		}
+	}
+	if v, ok := nMap["VxlanID"]; ok {
+		ncfg.VxlanID = int(v.(float64))
+	}
+	if v, ok := nMap["VxlanDev"]; ok {
+		ncfg.VxlanDev = v.(string)
+	}
+	if v, ok := nMap["VxlanGroup"]; ok {
+		ncfg.VxlanGroup = net.ParseIP(v.(string))
+	}
+	if v, ok := nMap["VxlanRemotes"]; ok {
+		for _, remote := range v.([]interface{}) {
+			ncfg.VxlanRemotes = append(ncfg.VxlanRemotes, net.ParseIP(remote.(string)))
+		}
+	}
+
+	d, _ := json.Marshal(nMap["Uplinks"])
+	if err := json.Unmarshal(d, &ncfg.Uplinks); err != nil {
+		return types.InternalErrorf("failed to decode bridge network uplinks after json unmarshal: %v", err)
+	}
+	d, _ = json.Marshal(nMap["UplinkStates"])
+	if err := json.Unmarshal(d, &ncfg.uplinkStates); err != nil {
+		return types.InternalErrorf("failed to decode bridge network uplink states after json unmarshal: %v", err)
+	}
+
+	return nil
+}
+
+func (ep *bridgeEndpoint) storePrefix() string {
+	return endpointStorePrefix
+}
+
+func (ep *bridgeEndpoint) storeID() string {
+	return ep.id
+}
+
+func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
+	epMap := make(map[string]interface{})
+	epMap["id"] = ep.id
+	epMap["nid"] = ep.nid
+	epMap["HostName"] = ep.hostName
+	epMap["SrcName"] = ep.srcName
+	epMap["Config"] = ep.config
+	epMap["ExposedPorts"] = ep.exposedPorts
+	epMap["External"] = ep.external
+	epMap["PortMapping"] = ep.portMapping
+
+	if ep.macAddress != nil {
+		epMap["MacAddress"] = ep.macAddress.String()
+	}
+	if ep.addr != nil {
+		epMap["Addr"] = ep.addr.String()
+	}
+	if ep.addrv6 != nil {
+		epMap["Addrv6"] = ep.addrv6.String()
+	}
+	if ep.gatewayv4 != nil {
+		epMap["GatewayIPv4"] = ep.gatewayv4.String()
+	}
+	if ep.gatewayv6 != nil {
+		epMap["GatewayIPv6"] = ep.gatewayv6.String()
+	}
+
+	return json.Marshal(epMap)
+}
+
+func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
+	var (
+		err   error
+		epMap map[string]interface{}
+	)
+
+	if err = json.Unmarshal(b, &epMap); err != nil {
+		return fmt.Errorf("Failed to unmarshal to bridge endpoint: %v", err)
+	}
+
+	if v, ok := epMap["MacAddress"]; ok {
+		if ep.macAddress, err = net.ParseMAC(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint MAC address (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+	if v, ok := epMap["Addr"]; ok {
+		if ep.addr, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint IPv4 address (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+	if v, ok := epMap["Addrv6"]; ok {
+		if ep.addrv6, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint IPv6 address (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+	if v, ok := epMap["GatewayIPv4"]; ok {
+		ep.gatewayv4 = net.ParseIP(v.(string))
+	}
+	if v, ok := epMap["GatewayIPv6"]; ok {
+		ep.gatewayv6 = net.ParseIP(v.(string))
+	}
+
+	ep.id = epMap["id"].(string)
+	ep.nid = epMap["nid"].(string)
+	ep.srcName = epMap["SrcName"].(string)
+	if v, ok := epMap["HostName"]; ok {
+		ep.hostName = v.(string)
+	}
+	if v, ok := epMap["External"]; ok {
+		ep.external = v.(bool)
+	}
+
+	d, _ := json.Marshal(epMap["Config"])
+	if err := json.Unmarshal(d, &ep.config); err != nil {
+		logrus.Warnf("Failed to decode endpoint config %v", err)
+	}
+	if v, ok := epMap["PortMapping"]; ok {
+		d, _ := json.Marshal(v)
+		if err := json.Unmarshal(d, &ep.portMapping); err != nil {
+			logrus.Warnf("Failed to decode endpoint port mapping %v", err)
+		}
+		// Bindings to any host address carry a nil host address, as they had when the ports were reserved.
+		for i := range ep.portMapping {
+			if len(ep.portMapping[i].HostIP) == 0 {
+				ep.portMapping[i].HostIP = nil
+			}
+		}
+	}
+	d, _ = json.Marshal(epMap["ExposedPorts"])
+	if err := json.Unmarshal(d, &ep.exposedPorts); err != nil {
+		logrus.Warnf("Failed to decode endpoint exposed ports %v", err)
+	}
+
+	return nil
+}
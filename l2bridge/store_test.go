@@ -0,0 +1,193 @@
+package l2bridge
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestNetworkConfigurationJSON(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name   string
+		config *networkConfiguration
+	}{
+		{
+			name:   "minimal",
+			config: &networkConfiguration{ID: "network0", BridgeName: "l2b-test0", Mtu: 1500},
+		},
+		{
+			name: "full",
+			config: &networkConfiguration{
+				ID:                   "network0",
+				BridgeName:           "l2b-test0",
+				EnableIPv6:           true,
+				Mtu:                  9000,
+				ContainerIfacePrefix: "eth",
+				Uplinks:              []string{"eth1"},
+				uplinkStates:         map[string]*uplinkState{"eth1": {Master: "br0", Up: true}},
+				Parent:               "eth0",
+				VlanID:               10,
+				vlanCreated:          true,
+				StaticFdb:            true,
+				Antispoof:            true,
+				Isolated:             true,
+				HostGateway:          true,
+				Masquerade:           true,
+				IPv6AddrGenMode:      "eui64",
+				DHCP:                 true,
+				DHCPDNS:              []net.IP{net.ParseIP("192.168.0.53")},
+				Peers:                []string{"network1"},
+				Routes:               []*StaticRoute{{Destination: dst, RouteType: types.NEXTHOP, NextHop: net.ParseIP("192.168.0.254")}},
+				VxlanID:              42,
+				VxlanDev:             "eth2",
+				VxlanRemotes:         []net.IP{net.ParseIP("203.0.113.1")},
+				SubnetsIPv4: []*subnet{
+					{Pool: mustParseCIDR(t, "192.168.0.0/24"), Gateway: net.ParseIP("192.168.0.1")},
+					{Pool: mustParseCIDR(t, "10.1.0.0/16")},
+				},
+				SubnetsIPv6:        []*subnet{{Pool: mustParseCIDR(t, "2001:db8::/64"), Gateway: net.ParseIP("2001:db8::1")}},
+				DefaultGatewayIPv4: net.ParseIP("192.168.0.1"),
+				DefaultGatewayIPv6: net.ParseIP("2001:db8::1"),
+			},
+		},
+		{
+			name: "VLAN filtering",
+			config: &networkConfiguration{
+				ID:            "network0",
+				BridgeName:    "l2b-test0",
+				VlanFiltering: true,
+				NullIPAM:      true,
+				VxlanID:       42,
+				VxlanGroup:    net.ParseIP("239.1.1.1"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.config)
+		if err != nil {
+			t.Fatalf("%s: json.Marshal() failed: %v", tt.name, err)
+		}
+		got := &networkConfiguration{}
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Fatalf("%s: json.Unmarshal() failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.config) {
+			t.Errorf("%s: round trip = %+v, expected %+v", tt.name, got, tt.config)
+		}
+	}
+}
+
+func TestNetworkConfigurationLegacyJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want *networkConfiguration
+	}{
+		{
+			name: "IPv4 pool",
+			json: `{"ID": "network0", "BridgeName": "l2b-test0", "EnableIPv6": false, "Mtu": 1500,
+				"PoolIPv4": "192.168.0.0/24", "DefaultGatewayIPv4": "192.168.0.1"}`,
+			want: &networkConfiguration{
+				ID:                 "network0",
+				BridgeName:         "l2b-test0",
+				Mtu:                1500,
+				SubnetsIPv4:        []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24"), Gateway: net.ParseIP("192.168.0.1")}},
+				DefaultGatewayIPv4: net.ParseIP("192.168.0.1"),
+			},
+		},
+		{
+			name: "IPv4 and IPv6 pools",
+			json: `{"ID": "network0", "BridgeName": "l2b-test0", "EnableIPv6": true, "Mtu": 1500,
+				"PoolIPv4": "192.168.0.0/24", "PoolIPv6": "2001:db8::/64", "DefaultGatewayIPv6": "2001:db8::1"}`,
+			want: &networkConfiguration{
+				ID:                 "network0",
+				BridgeName:         "l2b-test0",
+				EnableIPv6:         true,
+				Mtu:                1500,
+				SubnetsIPv4:        []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}},
+				SubnetsIPv6:        []*subnet{{Pool: mustParseCIDR(t, "2001:db8::/64"), Gateway: net.ParseIP("2001:db8::1")}},
+				DefaultGatewayIPv6: net.ParseIP("2001:db8::1"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := &networkConfiguration{}
+		if err := json.Unmarshal([]byte(tt.json), got); err != nil {
+			t.Fatalf("%s: json.Unmarshal() failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: json.Unmarshal() = %+v, expected %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBridgeEndpointJSON(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:c0:a8:00:02")
+	tests := []struct {
+		name string
+		ep   *bridgeEndpoint
+	}{
+		{
+			name: "minimal",
+			ep:   &bridgeEndpoint{id: "endpoint0", nid: "network0", srcName: "veth0"},
+		},
+		{
+			name: "full",
+			ep: &bridgeEndpoint{
+				id:         "endpoint0",
+				nid:        "network0",
+				hostName:   "veth1",
+				srcName:    "veth0",
+				addr:       mustParseCIDR(t, "192.168.0.2/24"),
+				addrv6:     mustParseCIDR(t, "2001:db8::2/64"),
+				gatewayv4:  net.ParseIP("192.168.0.1"),
+				gatewayv6:  net.ParseIP("2001:db8::1"),
+				macAddress: mac,
+				config: &endpointConfiguration{
+					MacAddress:  mac,
+					VlanID:      10,
+					Promiscuous: true,
+					GatewayIPv4: net.ParseIP("192.168.0.254"),
+				},
+				exposedPorts: []types.TransportPort{{Proto: types.TCP, Port: 80}},
+				external:     true,
+				portMapping: []types.PortBinding{
+					{Proto: types.TCP, IP: net.ParseIP("192.168.0.2"), Port: 80, HostPort: 8080, HostPortEnd: 8080},
+					{Proto: types.UDP, IP: net.ParseIP("2001:db8::2"), Port: 53, HostIP: net.ParseIP("::1"), HostPort: 5353, HostPortEnd: 5353},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.ep)
+		if err != nil {
+			t.Fatalf("%s: json.Marshal() failed: %v", tt.name, err)
+		}
+		got := &bridgeEndpoint{}
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Fatalf("%s: json.Unmarshal() failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.ep) {
+			t.Errorf("%s: round trip = %+v, expected %+v", tt.name, got, tt.ep)
+		}
+	}
+}
+
+func TestBridgeEndpointEmptyHostIPJSON(t *testing.T) {
+	b := `{"id": "endpoint0", "nid": "network0", "SrcName": "veth0", "External": true,
+		"PortMapping": [{"Proto": 6, "IP": "192.168.0.2", "Port": 80, "HostIP": "", "HostPort": 8080, "HostPortEnd": 8080}]}`
+	ep := &bridgeEndpoint{}
+	if err := json.Unmarshal([]byte(b), ep); err != nil {
+		t.Fatal(err)
+	}
+	if len(ep.portMapping) != 1 || ep.portMapping[0].HostIP != nil {
+		t.Errorf("json.Unmarshal() = %v, expected a binding to any host address", ep.portMapping)
+	}
+}
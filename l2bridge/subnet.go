@@ -0,0 +1,166 @@
+package l2bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// subnet is an address pool of a network, and the default gateway of the endpoints addressed from it. A network is a
+// single broadcast domain, so it may carry any number of subnets.
+type subnet struct {
+	Pool    *net.IPNet
+	Gateway net.IP
+}
+
+// subnets returns the IPv4 or IPv6 subnets of the network, depending on the family of the address.
+func (c *networkConfiguration) subnets(ip net.IP) []*subnet {
+	if ip.To4() != nil {
+		return c.SubnetsIPv4
+	}
+	return c.SubnetsIPv6
+}
+
+// subnetOf returns the subnet of the network containing the address, or nil if there is none.
+func (c *networkConfiguration) subnetOf(ip net.IP) *subnet {
+	for _, sn := range c.subnets(ip) {
+		if sn.Pool.Contains(ip) {
+			return sn
+		}
+	}
+	return nil
+}
+
+// gatewayOf returns the default gateway of an endpoint with the given address: the gateway of the subnet the address
+// falls in, or of the first subnet with one if the endpoint has no address of this family.
+func gatewayOf(subnets []*subnet, addr *net.IPNet) net.IP {
+	for _, sn := range subnets {
+		if addr == nil && sn.Gateway != nil {
+			return sn.Gateway
+		}
+		if addr != nil && sn.Pool.Contains(addr.IP) {
+			return sn.Gateway
+		}
+	}
+	return nil
+}
+
+func (sn *subnet) MarshalJSON() ([]byte, error) {
+	sMap := map[string]string{"Pool": sn.Pool.String()}
+	if sn.Gateway != nil {
+		sMap["Gateway"] = sn.Gateway.String()
+	}
+	return json.Marshal(sMap)
+}
+
+func (sn *subnet) UnmarshalJSON(b []byte) error {
+	var (
+		err  error
+		sMap map[string]string
+	)
+
+	if err = json.Unmarshal(b, &sMap); err != nil {
+		return err
+	}
+	if sn.Pool, err = types.ParseCIDR(sMap["Pool"]); err != nil {
+		return types.InternalErrorf("failed to decode subnet pool %s after json unmarshal: %v", sMap["Pool"], err)
+	}
+	if v, ok := sMap["Gateway"]; ok {
+		sn.Gateway = net.ParseIP(v)
+	}
+	return nil
+}
+
+// segment is a layer 2 segment a network is attached to: the wire of a host interface or one of its VLANs, or a VLAN
+// of a bridge shared by several networks.
+type segment struct {
+	dev  string
+	vlan int
+}
+
+func (s segment) String() string {
+	if s.vlan == 0 {
+		return s.dev
+	}
+	return fmt.Sprintf("VLAN %d on %s", s.vlan, s.dev)
+}
+
+// segments returns the segments the network is attached to by its uplinks and VLAN sub-interface. Uplinks which are
+// themselves VLAN sub-interfaces or macvlans are resolved to their parent, as their frames end up on its wire. A VLAN
+// filtering bridge is a segment of its own, shared by the networks on it: their endpoints are placed on its default
+// VLAN unless given another one.
+func (d *bridgeDriver) segments(config *networkConfiguration) []segment {
+	var segments []segment
+	if config.VlanFiltering {
+		segments = append(segments, segment{dev: config.BridgeName, vlan: defaultVlanID})
+	}
+	for _, name := range config.Uplinks {
+		segments = append(segments, d.uplinkSegment(name))
+	}
+	if config.Parent != "" {
+		segments = append(segments, segment{dev: config.Parent, vlan: config.VlanID})
+	}
+	return segments
+}
+
+func (d *bridgeDriver) uplinkSegment(name string) segment {
+	link, err := d.nlh.LinkByName(name)
+	if err != nil || link.Attrs().ParentIndex == 0 {
+		return segment{dev: name}
+	}
+	parent, err := d.nlh.LinkByIndex(link.Attrs().ParentIndex)
+	if err != nil {
+		return segment{dev: name}
+	}
+
+	switch l := link.(type) {
+	case *netlink.Vlan:
+		return segment{dev: parent.Attrs().Name, vlan: l.VlanId}
+	case *netlink.Macvlan:
+		return segment{dev: parent.Attrs().Name}
+	}
+	return segment{dev: name}
+}
+
+// checkSubnetConflicts ensures that no other network attached to the same segment as config has a subnet overlapping
+// one of config. Such networks are a single broadcast domain, where the overlapping addresses would collide.
+func (d *bridgeDriver) checkSubnetConflicts(config *networkConfiguration) error {
+	segments := d.segments(config)
+	if len(segments) == 0 {
+		return nil
+	}
+	subnets := append(append([]*subnet{}, config.SubnetsIPv4...), config.SubnetsIPv6...)
+
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		other := n.config
+		n.Unlock()
+
+		seg, shared := sharedSegment(segments, d.segments(other))
+		if !shared {
+			continue
+		}
+		for _, sn := range subnets {
+			for _, otherSn := range append(append([]*subnet{}, other.SubnetsIPv4...), other.SubnetsIPv6...) {
+				if sn.Pool.Contains(otherSn.Pool.IP) || otherSn.Pool.Contains(sn.Pool.IP) {
+					return types.ForbiddenErrorf("subnet %s overlaps subnet %s of network %s on %s", sn.Pool, otherSn.Pool, other.ID, seg)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sharedSegment(a, b []segment) (segment, bool) {
+	for _, sa := range a {
+		for _, sb := range b {
+			if sa == sb {
+				return sa, true
+			}
+		}
+	}
+	return segment{}, false
+}
@@ -0,0 +1,157 @@
+package l2bridge
+
+import (
+	"testing"
+)
+
+func TestSharedSegment(t *testing.T) {
+	eth0, eth1 := segment{dev: "eth0"}, segment{dev: "eth1"}
+	vlan10, vlan20 := segment{dev: "eth0", vlan: 10}, segment{dev: "eth0", vlan: 20}
+
+	tests := []struct {
+		a, b   []segment
+		want   segment
+		shared bool
+	}{
+		{a: nil, b: []segment{eth0}},
+		{a: []segment{eth0}, b: []segment{eth0}, want: eth0, shared: true},
+		{a: []segment{eth0}, b: []segment{eth1}},
+		{a: []segment{eth0}, b: []segment{vlan10}},
+		{a: []segment{vlan10}, b: []segment{vlan20}},
+		{a: []segment{vlan10}, b: []segment{vlan10}, want: vlan10, shared: true},
+		{a: []segment{eth1, vlan10}, b: []segment{vlan20, vlan10}, want: vlan10, shared: true},
+	}
+
+	for _, tt := range tests {
+		got, shared := sharedSegment(tt.a, tt.b)
+		if shared != tt.shared || got != tt.want {
+			t.Errorf("sharedSegment(%v, %v) = %s, %t, expected %s, %t", tt.a, tt.b, got, shared, tt.want, tt.shared)
+		}
+	}
+}
+
+func TestCheckSubnetConflicts(t *testing.T) {
+	d := NewBridgeDriver(&Configuration{})
+	existing := &networkConfiguration{
+		ID:          "existing",
+		Parent:      "eth0",
+		VlanID:      10,
+		SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}},
+		SubnetsIPv6: []*subnet{{Pool: mustParseCIDR(t, "2001:db8::/64")}},
+	}
+	d.networks[existing.ID] = &bridgeNetwork{id: existing.ID, config: existing}
+	filtering := &networkConfiguration{
+		ID:            "filtering",
+		BridgeName:    "l2b-test0",
+		VlanFiltering: true,
+		SubnetsIPv4:   []*subnet{{Pool: mustParseCIDR(t, "10.0.0.0/24")}},
+	}
+	d.networks[filtering.ID] = &bridgeNetwork{id: filtering.ID, config: filtering}
+
+	tests := []struct {
+		name    string
+		config  *networkConfiguration
+		wantErr bool
+	}{
+		{
+			name:   "not attached",
+			config: &networkConfiguration{SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}}},
+		},
+		{
+			name: "other VLAN",
+			config: &networkConfiguration{
+				Parent:      "eth0",
+				VlanID:      20,
+				SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}},
+			},
+		},
+		{
+			name: "other interface",
+			config: &networkConfiguration{
+				Parent:      "eth1",
+				VlanID:      10,
+				SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}},
+			},
+		},
+		{
+			name: "disjoint subnets",
+			config: &networkConfiguration{
+				Parent:      "eth0",
+				VlanID:      10,
+				SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.1.0/24")}},
+				SubnetsIPv6: []*subnet{{Pool: mustParseCIDR(t, "2001:db8:1::/64")}},
+			},
+		},
+		{
+			name: "same subnet",
+			config: &networkConfiguration{
+				Parent:      "eth0",
+				VlanID:      10,
+				SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/24")}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "larger subnet",
+			config: &networkConfiguration{
+				Parent:      "eth0",
+				VlanID:      10,
+				SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.0/16")}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "smaller subnet",
+			config: &networkConfiguration{
+				Parent:      "eth0",
+				VlanID:      10,
+				SubnetsIPv4: []*subnet{{Pool: mustParseCIDR(t, "192.168.0.128/25")}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "IPv6 subnet",
+			config: &networkConfiguration{
+				Parent:      "eth0",
+				VlanID:      10,
+				SubnetsIPv6: []*subnet{{Pool: mustParseCIDR(t, "2001:db8::/48")}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "other VLAN filtering bridge",
+			config: &networkConfiguration{
+				BridgeName:    "l2b-test1",
+				VlanFiltering: true,
+				SubnetsIPv4:   []*subnet{{Pool: mustParseCIDR(t, "10.0.0.0/24")}},
+			},
+		},
+		{
+			name: "disjoint subnets on VLAN filtering bridge",
+			config: &networkConfiguration{
+				BridgeName:    "l2b-test0",
+				VlanFiltering: true,
+				SubnetsIPv4:   []*subnet{{Pool: mustParseCIDR(t, "10.0.1.0/24")}},
+			},
+		},
+		{
+			name: "same subnet on VLAN filtering bridge",
+			config: &networkConfiguration{
+				BridgeName:    "l2b-test0",
+				VlanFiltering: true,
+				SubnetsIPv4:   []*subnet{{Pool: mustParseCIDR(t, "10.0.0.0/24")}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		err := d.checkSubnetConflicts(tt.config)
+		if tt.wantErr && err == nil {
+			t.Errorf("%s: checkSubnetConflicts() succeeded, expected an error", tt.name)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("%s: checkSubnetConflicts() failed: %v", tt.name, err)
+		}
+	}
+}
@@ -12,4 +12,79 @@ const (
 
 	// GatewayIPv6 label to specify a network's IPv6 default gateway.
 	GatewayIPv6 = "l2bridge.ipv6.gateway"
+
+	// IPv6AddrGenMode label to specify how IPv6 addresses are generated for endpoints IPAM gave none to: eui64,
+	// stable-privacy or random.
+	IPv6AddrGenMode = "l2bridge.ipv6.addr_gen_mode"
+
+	// Uplink label to specify a comma-separated list of host interfaces to attach to a network's bridge.
+	Uplink = "l2bridge.uplink"
+
+	// Parent label to specify a host interface on which to create a VLAN sub-interface uplink for a network.
+	Parent = "l2bridge.parent"
+
+	// Vlan label to specify the 802.1Q VLAN ID of a network's sub-interface uplink.
+	Vlan = "l2bridge.vlan"
+
+	// VlanFiltering label to create a network's bridge with VLAN filtering, allowing it to be shared by networks.
+	VlanFiltering = "l2bridge.vlan_filtering"
+
+	// StaticFdb label to pin endpoint MAC addresses in the bridge forwarding database, disable learning and flooding
+	// on endpoint ports, and answer neighbour discovery for endpoint addresses from the bridge.
+	StaticFdb = "l2bridge.static_fdb"
+
+	// Antispoof label to drop frames from endpoints which do not carry their assigned MAC and IP addresses.
+	Antispoof = "l2bridge.antispoof"
+
+	// Isolated label to prevent the endpoints of a network from reaching each other, except promiscuous ones.
+	Isolated = "l2bridge.isolated"
+
+	// Peers label to specify a comma-separated list of networks, by ID or bridge name, allowed to forward to a network.
+	Peers = "l2bridge.peers"
+
+	// HostGateway label to assign a network's gateway addresses to its bridge, making the host its gateway.
+	HostGateway = "l2bridge.host_gateway"
+
+	// Masquerade label to masquerade traffic from a host gateway network leaving through other interfaces.
+	Masquerade = "l2bridge.masquerade"
+
+	// Routes label to specify a semicolon-separated list of static routes pushed to the endpoints of a network, each
+	// either "<destination> via <next hop>" or "<destination> connected".
+	Routes = "l2bridge.routes"
+
+	// DHCP label to serve the addresses of a network's endpoints over DHCPv4 from the driver.
+	DHCP = "l2bridge.dhcp"
+
+	// DHCPDNS label to specify a comma-separated list of DNS servers handed out by a network's DHCP server.
+	DHCPDNS = "l2bridge.dhcp.dns"
+
+	// EndpointVlan label to specify the access VLAN of an endpoint on a VLAN filtering bridge.
+	EndpointVlan = "l2bridge.endpoint.vlan"
+
+	// EndpointPromiscuous label to let an endpoint on an isolated network reach all other endpoints.
+	EndpointPromiscuous = "l2bridge.endpoint.promiscuous"
+
+	// EndpointRoutes label to specify static routes for an endpoint, replacing those of its network.
+	EndpointRoutes = "l2bridge.endpoint.routes"
+
+	// EndpointGatewayIPv4 label to specify an endpoint's IPv4 default gateway, overriding the one of its network.
+	EndpointGatewayIPv4 = "l2bridge.endpoint.gateway"
+
+	// EndpointGatewayIPv6 label to specify an endpoint's IPv6 default gateway, overriding the one of its network.
+	EndpointGatewayIPv6 = "l2bridge.endpoint.ipv6.gateway"
+
+	// EndpointNoGateway label to give an endpoint no default gateway on its network.
+	EndpointNoGateway = "l2bridge.endpoint.nogateway"
+
+	// VxlanVNI label to specify the VXLAN network identifier of a network's VXLAN uplink.
+	VxlanVNI = "l2bridge.vxlan.vni"
+
+	// VxlanRemote label to specify a comma-separated list of VXLAN peers to which broadcast traffic is replicated.
+	VxlanRemote = "l2bridge.vxlan.remote"
+
+	// VxlanGroup label to specify the multicast group of a network's VXLAN uplink.
+	VxlanGroup = "l2bridge.vxlan.group"
+
+	// VxlanDev label to specify the underlay interface of a network's VXLAN uplink.
+	VxlanDev = "l2bridge.vxlan.dev"
 )
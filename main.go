@@ -1,16 +1,43 @@
 package main
 
 import (
+	"os"
+
+	"github.com/docker/go-plugins-helpers/ipam"
 	"github.com/docker/go-plugins-helpers/network"
 	"github.com/nategraf/l2bridge-driver/l2bridge"
-)
-
-const (
-	socketAddress = "/run/docker/plugins/l2bridge.sock"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	d := l2bridge.NewDriver()
+	opts, err := parseOptions(os.Args[0], os.Args[1:])
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to parse options")
+	}
+	if err := opts.setupLogging(); err != nil {
+		logrus.WithError(err).Fatal("Failed to setup logging")
+	}
+
+	d, err := l2bridge.NewDriver(opts.driverConfig())
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to initialize driver")
+	}
+
+	// The IPAM driver is a plugin of its own, as a plugin only activates a single handler.
+	if opts.IPAM {
+		i, err := l2bridge.NewIPAMDriver(opts.driverConfig())
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to initialize IPAM driver")
+		}
+		go func() {
+			if err := ipam.NewHandler(i).ServeUnix(opts.ipamSocketAddress(), 0); err != nil {
+				logrus.WithError(err).Fatal("Failed to serve IPAM plugin")
+			}
+		}()
+	}
+
 	h := network.NewHandler(d)
-	h.ServeUnix(socketAddress, 0)
+	if err := h.ServeUnix(opts.socketAddress(), 0); err != nil {
+		logrus.WithError(err).Fatal("Failed to serve plugin")
+	}
 }